@@ -0,0 +1,227 @@
+package grpcstub
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/bufbuild/protocompile"
+	"github.com/bufbuild/protocompile/linker"
+	"github.com/goccy/go-yaml"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const adminProtoPath = "grpcstub/admin.proto"
+
+//go:embed proto/grpcstub/admin.proto
+var adminProto string
+
+var (
+	adminFds     linker.Files
+	adminFdsErr  error
+	adminFdsOnce sync.Once
+)
+
+type adminHandlerFunc func(ctx context.Context, in Message) (any, error)
+
+// adminServiceDescriptor returns the descriptor of grpcstub.admin.Admin.
+func adminServiceDescriptor() (protoreflect.ServiceDescriptor, error) {
+	adminFdsOnce.Do(func() {
+		comp := protocompile.Compiler{
+			Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{
+				Accessor: protocompile.SourceAccessorFromMap(map[string]string{
+					adminProtoPath: adminProto,
+				}),
+			}),
+		}
+		adminFds, adminFdsErr = comp.Compile(context.Background(), adminProtoPath)
+		if adminFdsErr != nil {
+			return
+		}
+		adminFdsErr = registerFiles(adminFds)
+	})
+	if adminFdsErr != nil {
+		return nil, adminFdsErr
+	}
+	return adminFds[0].Services().ByName("Admin"), nil
+}
+
+func (s *Server) registerAdminServer() error {
+	sd, err := adminServiceDescriptor()
+	if err != nil {
+		return err
+	}
+	handlers := map[protoreflect.Name]adminHandlerFunc{
+		"AddMatcher":           s.adminAddMatcher,
+		"RemoveMatcher":        s.adminRemoveMatcher,
+		"ListMatchers":         s.adminListMatchers,
+		"GetRequests":          s.adminGetRequests,
+		"GetUnmatchedRequests": s.adminGetUnmatchedRequests,
+		"ClearRequests":        s.adminClearRequests,
+		"Reset":                s.adminReset,
+	}
+	gsd := &grpc.ServiceDesc{
+		ServiceName: string(sd.FullName()),
+		HandlerType: nil,
+		Metadata:    sd.ParentFile().Path(),
+	}
+	for i := 0; i < sd.Methods().Len(); i++ {
+		md := sd.Methods().Get(i)
+		fn, ok := handlers[md.Name()]
+		if !ok {
+			return fmt.Errorf("admin handler not found: %s", md.Name())
+		}
+		gsd.Methods = append(gsd.Methods, grpc.MethodDesc{
+			MethodName: string(md.Name()),
+			Handler:    createAdminHandler(md, fn, nil),
+		})
+	}
+	s.server.RegisterService(gsd, nil)
+	return nil
+}
+
+func createAdminHandler(md protoreflect.MethodDescriptor, fn adminHandlerFunc, r *resolver) func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+	return func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+		in := dynamicpb.NewMessage(md.Input())
+		if err := dec(in); err != nil {
+			return nil, err
+		}
+		m, err := MarshalProtoMessage(in)
+		if err != nil {
+			return nil, err
+		}
+		res, err := fn(ctx, m)
+		if err != nil {
+			return nil, err
+		}
+		out := dynamicpb.NewMessage(md.Output())
+		if res == nil {
+			return out, nil
+		}
+		o, err := normalizeValue(res)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		om, ok := o.(map[string]any)
+		if !ok {
+			return nil, status.Errorf(codes.Internal, "invalid admin response: %v", o)
+		}
+		if err := unmarshalProtoMessage(om, out, r); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		return out, nil
+	}
+}
+
+func (s *Server) adminAddMatcher(_ context.Context, in Message) (any, error) {
+	b, err := json.Marshal(in["stub"])
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	st := &stub{}
+	if err := yaml.UnmarshalWithOptions(b, st, yaml.DisallowUnknownField()); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid stub: %v", err)
+	}
+	m, err := s.addStub(st)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid stub: %v", err)
+	}
+	return adminMatcher(m), nil
+}
+
+func (s *Server) adminRemoveMatcher(_ context.Context, in Message) (any, error) {
+	id, _ := in["id"].(string)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.matchers, func(m *matcher) bool { return m.id == id })
+	if i < 0 {
+		return nil, status.Errorf(codes.NotFound, "matcher not found: %s", id)
+	}
+	s.matchers = slices.Delete(slices.Clone(s.matchers), i, i+1)
+	return nil, nil
+}
+
+func (s *Server) adminListMatchers(_ context.Context, _ Message) (any, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	matchers := []any{}
+	for _, m := range s.matchers {
+		matchers = append(matchers, adminMatcher(m))
+	}
+	return map[string]any{"matchers": matchers}, nil
+}
+
+func (s *Server) adminGetRequests(_ context.Context, _ Message) (any, error) {
+	return map[string]any{"requests": adminRequests(s.Requests())}, nil
+}
+
+func (s *Server) adminGetUnmatchedRequests(_ context.Context, _ Message) (any, error) {
+	return map[string]any{"requests": adminRequests(s.UnmatchedRequests())}, nil
+}
+
+func (s *Server) adminClearRequests(_ context.Context, _ Message) (any, error) {
+	s.ClearRequests()
+	return nil, nil
+}
+
+func (s *Server) adminReset(_ context.Context, _ Message) (any, error) {
+	if err := s.reset(); err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return nil, nil
+}
+
+func adminMatcher(m *matcher) map[string]any {
+	am := map[string]any{"id": m.id}
+	if m.stub != nil {
+		am["stub"] = m.stub
+	}
+	return am
+}
+
+func adminRequests(rs []*Request) []any {
+	requests := []any{}
+	for _, r := range rs {
+		requests = append(requests, map[string]any{
+			"service": r.Service,
+			"method":  r.Method,
+			"headers": r.Headers,
+			"message": r.Message,
+		})
+	}
+	return requests
+}
+
+// reset clears the matchers, the requests, the scenarios and the operations, and reloads the stub files and the records.
+func (s *Server) reset() error {
+	s.mu.Lock()
+	s.matchers = nil
+	s.requests = nil
+	s.unmatchedRequests = nil
+	s.prependOnce = false
+	s.mu.Unlock()
+	s.scenarioMu.Lock()
+	s.scenarios = nil
+	s.scenarioMu.Unlock()
+	s.operationMu.Lock()
+	s.operations = nil
+	s.operationMu.Unlock()
+	for _, p := range s.replayPaths {
+		if err := s.replay(p); err != nil {
+			return err
+		}
+	}
+	for _, p := range s.stubPaths {
+		if err := s.loadStubs(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
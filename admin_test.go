@@ -0,0 +1,178 @@
+package grpcstub
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/k1LoW/grpcstub/testdata/routeguide"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func TestAdmin(t *testing.T) {
+	ctx := context.Background()
+	ts := NewServer(t, "testdata/route_guide.proto", EnableAdmin(), StubFile("testdata/stubs/default.json"))
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	client := routeguide.NewRouteGuideClient(ts.Conn())
+
+	added := invokeAdmin(t, ts, "AddMatcher", Message{
+		"stub": map[string]any{
+			"method": "GetFeature",
+			"match":  map[string]any{"message": map[string]any{"latitude": 10}},
+			"response": map[string]any{
+				"messages": []any{map[string]any{"name": "admin"}},
+			},
+		},
+	})
+	id, _ := added["id"].(string)
+	if id == "" {
+		t.Fatalf("got %v\nwant id", added)
+	}
+
+	res, err := client.GetFeature(ctx, &routeguide.Point{Latitude: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The stub file is loaded first, so the default stub matches.
+	if want := "default"; res.Name != want {
+		t.Errorf("got %v\nwant %v", res.Name, want)
+	}
+
+	listed := invokeAdmin(t, ts, "ListMatchers", Message{})
+	matchers, _ := listed["matchers"].([]any)
+	if len(matchers) != 2 {
+		t.Fatalf("got %v\nwant 2 matchers", listed)
+	}
+	first, _ := matchers[0].(map[string]any)
+	invokeAdmin(t, ts, "RemoveMatcher", Message{"id": first["id"]})
+
+	res, err = client.GetFeature(ctx, &routeguide.Point{Latitude: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "admin"; res.Name != want {
+		t.Errorf("got %v\nwant %v", res.Name, want)
+	}
+	if _, err := client.GetFeature(ctx, &routeguide.Point{Latitude: 20}); status.Code(err) != codes.NotFound {
+		t.Errorf("got %v\nwant %v", status.Code(err), codes.NotFound)
+	}
+
+	{
+		got := invokeAdmin(t, ts, "GetRequests", Message{})
+		requests, _ := got["requests"].([]any)
+		if len(requests) != 2 {
+			t.Fatalf("got %v\nwant 2 requests", got)
+		}
+		r, _ := requests[0].(map[string]any)
+		if diff := cmp.Diff(r["message"], map[string]any{"latitude": float64(10), "longitude": float64(0)}); diff != "" {
+			t.Error(diff)
+		}
+	}
+	{
+		got := invokeAdmin(t, ts, "GetUnmatchedRequests", Message{})
+		requests, _ := got["requests"].([]any)
+		if len(requests) != 1 {
+			t.Errorf("got %v\nwant 1 request", got)
+		}
+	}
+
+	invokeAdmin(t, ts, "ClearRequests", Message{})
+	if got := len(ts.Requests()) + len(ts.UnmatchedRequests()); got != 0 {
+		t.Errorf("got %v\nwant %v", got, 0)
+	}
+
+	invokeAdmin(t, ts, "Reset", Message{})
+	res, err = client.GetFeature(ctx, &routeguide.Point{Latitude: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "default"; res.Name != want {
+		t.Errorf("got %v\nwant %v", res.Name, want)
+	}
+}
+
+func TestAdminClearConcurrently(t *testing.T) {
+	ctx := context.Background()
+	ts := NewServer(t, "testdata/route_guide.proto", EnableAdmin())
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	client := routeguide.NewRouteGuideClient(ts.Conn())
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			ts.ClearMatchers()
+			ts.ClearRequests()
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := ts.adminClearRequests(ctx, Message{}); err != nil {
+				t.Error(err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			_, _ = client.GetFeature(ctx, &routeguide.Point{})
+		}()
+	}
+	wg.Wait()
+}
+
+func TestAdminInvalid(t *testing.T) {
+	ts := NewServer(t, "testdata/route_guide.proto", EnableAdmin())
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	tests := []struct {
+		method string
+		in     Message
+		want   codes.Code
+	}{
+		{"AddMatcher", Message{"stub": map[string]any{"method": "Unknown"}}, codes.InvalidArgument},
+		{"AddMatcher", Message{"stub": map[string]any{"methods": "GetFeature"}}, codes.InvalidArgument},
+		{"RemoveMatcher", Message{"id": "999"}, codes.NotFound},
+	}
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			_, err := callAdmin(ts, tt.method, tt.in)
+			if got := status.Code(err); got != tt.want {
+				t.Errorf("got %v\nwant %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func invokeAdmin(t *testing.T, ts *Server, method string, in Message) Message {
+	t.Helper()
+	out, err := callAdmin(ts, method, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func callAdmin(ts *Server, method string, in Message) (Message, error) {
+	sd, err := adminServiceDescriptor()
+	if err != nil {
+		return nil, err
+	}
+	md := sd.Methods().ByName(protoreflect.Name(method))
+	req := dynamicpb.NewMessage(md.Input())
+	if err := UnmarshalProtoMessage(in, req); err != nil {
+		return nil, err
+	}
+	res := dynamicpb.NewMessage(md.Output())
+	if err := ts.Conn().Invoke(context.Background(), fmt.Sprintf("/%s/%s", sd.FullName(), method), req, res); err != nil {
+		return nil, err
+	}
+	return MarshalProtoMessage(res)
+}
@@ -0,0 +1,215 @@
+// Command grpcstub runs grpcstub as a standalone gRPC stub server.
+//
+//	grpcstub -proto path/to/route_guide.proto -stub path/to/stubs.yaml -addr 127.0.0.1:50051
+//
+// The same options can be set with a config file (-config).
+//
+//	addr: 127.0.0.1:50051
+//	protos:
+//	  - path/to/route_guide.proto
+//	importPaths:
+//	  - path/to/protobuf
+//	bufDirs:
+//	  - path/to/buf
+//	stubs:
+//	  - path/to/stubs
+//	healthCheck: true
+//	admin: true
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"syscall"
+
+	"github.com/goccy/go-yaml"
+	"github.com/k1LoW/grpcstub"
+)
+
+type config struct {
+	Addr              string   `yaml:"addr"`
+	Protos            []string `yaml:"protos"`
+	ImportPaths       []string `yaml:"importPaths"`
+	BufDirs           []string `yaml:"bufDirs"`
+	Stubs             []string `yaml:"stubs"`
+	HealthCheck       bool     `yaml:"healthCheck"`
+	DisableReflection bool     `yaml:"disableReflection"`
+	Admin             bool     `yaml:"admin"`
+	Connect           bool     `yaml:"connect"`
+	HTTPGateway       bool     `yaml:"httpGateway"`
+}
+
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
+
+// logTB is grpcstub.TB which logs errors instead of failing tests.
+// It records errors so that the command can exit with non-zero status.
+type logTB struct {
+	l      *log.Logger
+	failed atomic.Bool
+}
+
+func (t *logTB) Error(args ...any) {
+	t.failed.Store(true)
+	t.l.Print(args...)
+}
+
+func (t *logTB) Errorf(format string, args ...any) {
+	t.failed.Store(true)
+	t.l.Printf(format, args...)
+}
+
+func (t *logTB) Fatal(args ...any) {
+	t.l.Fatal(args...)
+}
+
+func (t *logTB) Fatalf(format string, args ...any) {
+	t.l.Fatalf(format, args...)
+}
+
+func (t *logTB) Helper() {}
+
+func main() {
+	l := log.New(os.Stderr, "grpcstub: ", log.LstdFlags)
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	if err := run(os.Args[1:], l, sig); err != nil {
+		l.Fatal(err)
+	}
+}
+
+// run starts the stub server and serves until a signal is received.
+func run(args []string, l *log.Logger, sig <-chan os.Signal) error {
+	c, err := parseFlags(args)
+	if err != nil {
+		return err
+	}
+	if len(c.Protos) == 0 && len(c.ImportPaths) == 0 && len(c.BufDirs) == 0 {
+		return errors.New("no protos: specify -proto, -import-path or -buf-dir")
+	}
+	opts := []grpcstub.Option{
+		listenOption(c.Addr),
+		grpcstub.Proto(c.Protos...),
+		grpcstub.ImportPath(c.ImportPaths...),
+		grpcstub.BufDir(c.BufDirs...),
+		grpcstub.StubFile(c.Stubs...),
+		grpcstub.OnRequest(func(req *grpcstub.Request) {
+			l.Printf("request:\n%s", req)
+		}),
+	}
+	if c.HealthCheck {
+		opts = append(opts, grpcstub.EnableHealthCheck())
+	}
+	if c.DisableReflection {
+		opts = append(opts, grpcstub.DisableReflection())
+	}
+	if c.Admin {
+		opts = append(opts, grpcstub.EnableAdmin())
+	}
+	if c.Connect {
+		opts = append(opts, grpcstub.EnableConnect())
+	}
+	if c.HTTPGateway {
+		opts = append(opts, grpcstub.EnableHTTPGateway())
+	}
+	tb := &logTB{l: l}
+	ts := grpcstub.NewServer(tb, "", opts...)
+	if tb.failed.Load() {
+		return errors.New("failed to start the server")
+	}
+	l.Printf("listening on %s", ts.Addr())
+
+	<-sig
+	ts.Close()
+	if tb.failed.Load() {
+		return errors.New("failed to stop the server")
+	}
+	return nil
+}
+
+// listenOption returns UnixSocket option for "unix:/path/to/sock", otherwise Addr option.
+func listenOption(addr string) grpcstub.Option {
+	if p, ok := strings.CutPrefix(addr, "unix:"); ok {
+		return grpcstub.UnixSocket(p)
+	}
+	return grpcstub.Addr(addr)
+}
+
+// parseFlags parses args and merges them into the config file specified by -config.
+func parseFlags(args []string) (*config, error) {
+	fs := flag.NewFlagSet("grpcstub", flag.ContinueOnError)
+	var (
+		configPath  string
+		addr        string
+		protos      stringsFlag
+		importPaths stringsFlag
+		bufDirs     stringsFlag
+		stubs       stringsFlag
+		healthCheck bool
+		noReflect   bool
+		admin       bool
+		connect     bool
+		httpGateway bool
+	)
+	fs.StringVar(&configPath, "config", "", "config file path")
+	fs.StringVar(&addr, "addr", "", "address to listen on (default 127.0.0.1:0, unix:/path/to/sock for Unix domain socket)")
+	fs.Var(&protos, "proto", "proto file path (can be specified multiple times)")
+	fs.Var(&importPaths, "import-path", "import path (can be specified multiple times)")
+	fs.Var(&bufDirs, "buf-dir", "buf directory (can be specified multiple times)")
+	fs.Var(&stubs, "stub", "stub definition file or directory (can be specified multiple times)")
+	fs.BoolVar(&healthCheck, "health-check", false, "enable grpc.health.v1")
+	fs.BoolVar(&noReflect, "disable-reflection", false, "disable Server Reflection Protocol")
+	fs.BoolVar(&admin, "admin", false, "enable grpcstub.admin.Admin service")
+	fs.BoolVar(&connect, "connect", false, "enable the Connect protocol and gRPC-Web")
+	fs.BoolVar(&httpGateway, "http-gateway", false, "enable HTTP/JSON gateway by google.api.http annotations")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	c := &config{}
+	if configPath != "" {
+		b, err := os.ReadFile(configPath)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.UnmarshalWithOptions(b, c, yaml.DisallowUnknownField()); err != nil {
+			return nil, fmt.Errorf("failed to load config %s: %w", configPath, err)
+		}
+		// Paths in the config file are relative to the config file.
+		dir := filepath.Dir(configPath)
+		for _, paths := range [][]string{c.Protos, c.ImportPaths, c.BufDirs, c.Stubs} {
+			for i, p := range paths {
+				if !filepath.IsAbs(p) {
+					paths[i] = filepath.Join(dir, p)
+				}
+			}
+		}
+	}
+	if addr != "" {
+		c.Addr = addr
+	}
+	c.Protos = append(c.Protos, protos...)
+	c.ImportPaths = append(c.ImportPaths, importPaths...)
+	c.BufDirs = append(c.BufDirs, bufDirs...)
+	c.Stubs = append(c.Stubs, stubs...)
+	c.HealthCheck = c.HealthCheck || healthCheck
+	c.DisableReflection = c.DisableReflection || noReflect
+	c.Admin = c.Admin || admin
+	c.Connect = c.Connect || connect
+	c.HTTPGateway = c.HTTPGateway || httpGateway
+	return c, nil
+}
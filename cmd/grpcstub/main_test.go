@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseFlags(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+		want *config
+	}{
+		{
+			"flags",
+			[]string{"-proto", "a.proto", "-proto", "b.proto", "-stub", "stubs.yaml", "-addr", ":50051"},
+			&config{Addr: ":50051", Protos: []string{"a.proto", "b.proto"}, Stubs: []string{"stubs.yaml"}},
+		},
+		{
+			"config file",
+			[]string{"-config", "testdata/config.yaml", "-stub", "more.yaml"},
+			&config{
+				Addr:        "127.0.0.1:50051",
+				Protos:      []string{"../../testdata/route_guide.proto"},
+				Stubs:       []string{"../../testdata/stubs", "more.yaml"},
+				HealthCheck: true,
+			},
+		},
+		{
+			"override addr",
+			[]string{"-config", "testdata/config.yaml", "-addr", "127.0.0.1:50052", "-disable-reflection"},
+			&config{
+				Addr:              "127.0.0.1:50052",
+				Protos:            []string{"../../testdata/route_guide.proto"},
+				Stubs:             []string{"../../testdata/stubs"},
+				HealthCheck:       true,
+				DisableReflection: true,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseFlags(tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestRun(t *testing.T) {
+	t.Run("serve until signal", func(t *testing.T) {
+		buf := new(bytes.Buffer)
+		sig := make(chan os.Signal, 1)
+		sig <- syscall.SIGTERM
+		if err := run([]string{"-proto", "../../testdata/route_guide.proto"}, log.New(buf, "", 0), sig); err != nil {
+			t.Errorf("got %v\nwant no error", err)
+		}
+		if !strings.Contains(buf.String(), "listening on 127.0.0.1:") {
+			t.Errorf("got %q\nwant listening log", buf.String())
+		}
+	})
+
+	t.Run("address already in use", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			_ = l.Close()
+		})
+		done := make(chan error, 1)
+		go func() {
+			done <- run([]string{"-proto", "../../testdata/route_guide.proto", "-addr", l.Addr().String()}, log.New(new(bytes.Buffer), "", 0), make(chan os.Signal))
+		}()
+		select {
+		case err := <-done:
+			if err == nil {
+				t.Error("want error")
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("run did not return")
+		}
+	})
+}
@@ -0,0 +1,289 @@
+package grpcstub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"connectrpc.com/connect"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	protov2 "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// httpHandler returns the handler serving the gRPC protocol by *grpc.Server,
+// the Connect protocol and gRPC-Web by connect handlers and HTTP/JSON by the gateway on the same listener.
+func (s *Server) httpHandler() (http.Handler, error) {
+	var connectHandler http.Handler = http.NotFoundHandler()
+	if s.connect {
+		mux := http.NewServeMux()
+		for _, fd := range s.fds {
+			for i := 0; i < fd.Services().Len(); i++ {
+				sd := fd.Services().Get(i)
+				for j := 0; j < sd.Methods().Len(); j++ {
+					md := sd.Methods().Get(j)
+					procedure := fmt.Sprintf("/%s/%s", sd.FullName(), md.Name())
+					mux.Handle(procedure, s.createConnectHandler(procedure, md))
+				}
+			}
+		}
+		connectHandler = mux
+	}
+	var routes []*httpRoute
+	if s.httpGateway {
+		var err error
+		routes, err = s.httpRoutes()
+		if err != nil {
+			return nil, err
+		}
+	}
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ct := r.Header.Get("Content-Type")
+		if r.ProtoMajor == 2 && strings.HasPrefix(ct, "application/grpc") && !strings.HasPrefix(ct, "application/grpc-web") {
+			s.server.ServeHTTP(w, r)
+			return
+		}
+		r = r.WithContext(peer.NewContext(r.Context(), httpPeer(r)))
+		if rt, values := matchHTTPRoute(routes, r); rt != nil {
+			s.serveHTTPRoute(w, r, rt, values)
+			return
+		}
+		connectHandler.ServeHTTP(w, r)
+	})
+	if s.tlsc != nil {
+		return h, nil
+	}
+	return h2c.NewHandler(h, &http2.Server{}), nil
+}
+
+// httpPeer returns peer.Peer of the HTTP request, same as the one of gRPC.
+func httpPeer(r *http.Request) *peer.Peer {
+	p := &peer.Peer{Addr: httpAddr(r.RemoteAddr)}
+	if r.TLS != nil {
+		p.AuthInfo = credentials.TLSInfo{State: *r.TLS}
+	}
+	return p
+}
+
+type httpAddr string
+
+func (a httpAddr) Network() string {
+	return "tcp"
+}
+
+func (a httpAddr) String() string {
+	return string(a)
+}
+
+func (s *Server) createConnectHandler(procedure string, md protoreflect.MethodDescriptor) http.Handler {
+	opts := []connect.HandlerOption{
+		connect.WithSchema(md),
+		connect.WithRequestInitializer(func(_ connect.Spec, msg any) error {
+			dm, ok := msg.(*dynamicpb.Message)
+			if !ok {
+				return fmt.Errorf("unexpected request type: %T", msg)
+			}
+			*dm = *dynamicpb.NewMessage(md.Input())
+			return nil
+		}),
+	}
+	switch {
+	case !md.IsStreamingClient() && !md.IsStreamingServer():
+		handler := s.createUnaryHandler(md)
+		return connect.NewUnaryHandler(procedure, func(ctx context.Context, req *connect.Request[dynamicpb.Message]) (*connect.Response[dynamicpb.Message], error) {
+			ts := &connectTransportStream{method: procedure, header: metadata.MD{}, trailer: metadata.MD{}}
+			ctx = grpc.NewContextWithServerTransportStream(incomingContext(ctx, req.Header()), ts)
+			out, err := handler(nil, ctx, func(m any) error {
+				protov2.Merge(m.(protov2.Message), req.Msg)
+				return nil
+			}, nil)
+			if err != nil {
+				ce := connectError(err)
+				appendHeader(ce.Meta(), ts.header)
+				appendHeader(ce.Meta(), ts.trailer)
+				return nil, ce
+			}
+			res := connect.NewResponse(out.(*dynamicpb.Message))
+			appendHeader(res.Header(), ts.header)
+			appendHeader(res.Trailer(), ts.trailer)
+			return res, nil
+		}, opts...)
+	case !md.IsStreamingClient() && md.IsStreamingServer():
+		handler := s.createServerStreamingHandler(md)
+		return connect.NewServerStreamHandler(procedure, func(ctx context.Context, req *connect.Request[dynamicpb.Message], stream *connect.ServerStream[dynamicpb.Message]) error {
+			conn := stream.Conn()
+			received := false
+			ss := &connectServerStream{
+				ctx:  incomingContext(ctx, req.Header()),
+				conn: conn,
+				recv: func(m any) error {
+					if received {
+						return io.EOF
+					}
+					received = true
+					protov2.Merge(m.(protov2.Message), req.Msg)
+					return nil
+				},
+				send: conn.Send,
+			}
+			if err := handler(nil, ss); err != nil {
+				return connectError(err)
+			}
+			return nil
+		}, opts...)
+	case md.IsStreamingClient() && !md.IsStreamingServer():
+		handler := s.createClientStreamingHandler(md)
+		return connect.NewClientStreamHandler(procedure, func(ctx context.Context, stream *connect.ClientStream[dynamicpb.Message]) (*connect.Response[dynamicpb.Message], error) {
+			conn := stream.Conn()
+			var out *dynamicpb.Message
+			ss := &connectServerStream{
+				ctx:  incomingContext(ctx, stream.RequestHeader()),
+				conn: conn,
+				recv: connectReceive(conn),
+				send: func(m any) error {
+					out = m.(*dynamicpb.Message)
+					return nil
+				},
+			}
+			if err := handler(nil, ss); err != nil {
+				return nil, connectError(err)
+			}
+			if out == nil {
+				out = dynamicpb.NewMessage(md.Output())
+			}
+			return connect.NewResponse(out), nil
+		}, opts...)
+	default:
+		handler := s.createBidiStreamingHandler(md)
+		return connect.NewBidiStreamHandler(procedure, func(ctx context.Context, stream *connect.BidiStream[dynamicpb.Message, dynamicpb.Message]) error {
+			conn := stream.Conn()
+			ss := &connectServerStream{
+				ctx:  incomingContext(ctx, stream.RequestHeader()),
+				conn: conn,
+				recv: connectReceive(conn),
+				send: conn.Send,
+			}
+			if err := handler(nil, ss); err != nil {
+				return connectError(err)
+			}
+			return nil
+		}, opts...)
+	}
+}
+
+// connectServerStream is grpc.ServerStream backed by connect.StreamingHandlerConn.
+type connectServerStream struct {
+	ctx  context.Context
+	conn connect.StreamingHandlerConn
+	recv func(m any) error
+	send func(m any) error
+}
+
+func (ss *connectServerStream) SetHeader(md metadata.MD) error {
+	appendHeader(ss.conn.ResponseHeader(), md)
+	return nil
+}
+
+func (ss *connectServerStream) SendHeader(md metadata.MD) error {
+	// The headers are sent with the first message.
+	appendHeader(ss.conn.ResponseHeader(), md)
+	return nil
+}
+
+func (ss *connectServerStream) SetTrailer(md metadata.MD) {
+	appendHeader(ss.conn.ResponseTrailer(), md)
+}
+
+func (ss *connectServerStream) Context() context.Context {
+	return ss.ctx
+}
+
+func (ss *connectServerStream) SendMsg(m any) error {
+	return ss.send(m)
+}
+
+func (ss *connectServerStream) RecvMsg(m any) error {
+	return ss.recv(m)
+}
+
+// connectTransportStream is grpc.ServerTransportStream which stores headers and trailers set by grpc.SetHeader and grpc.SetTrailer.
+type connectTransportStream struct {
+	method  string
+	header  metadata.MD
+	trailer metadata.MD
+}
+
+func (ts *connectTransportStream) Method() string {
+	return ts.method
+}
+
+func (ts *connectTransportStream) SetHeader(md metadata.MD) error {
+	ts.header = metadata.Join(ts.header, md)
+	return nil
+}
+
+func (ts *connectTransportStream) SendHeader(md metadata.MD) error {
+	return ts.SetHeader(md)
+}
+
+func (ts *connectTransportStream) SetTrailer(md metadata.MD) error {
+	ts.trailer = metadata.Join(ts.trailer, md)
+	return nil
+}
+
+func connectReceive(conn connect.StreamingHandlerConn) func(m any) error {
+	return func(m any) error {
+		if err := conn.Receive(m); err != nil {
+			if errors.Is(err, io.EOF) {
+				return io.EOF
+			}
+			return err
+		}
+		return nil
+	}
+}
+
+// incomingContext returns the context with the incoming metadata converted from the HTTP headers.
+func incomingContext(ctx context.Context, h http.Header) context.Context {
+	md := metadata.MD{}
+	for k, v := range h {
+		md.Append(strings.ToLower(k), v...)
+	}
+	return metadata.NewIncomingContext(ctx, md)
+}
+
+func appendHeader(h http.Header, md metadata.MD) {
+	for k, v := range md {
+		for _, vv := range v {
+			h.Add(k, vv)
+		}
+	}
+}
+
+// connectError converts the gRPC status error to *connect.Error.
+func connectError(err error) *connect.Error {
+	var ce *connect.Error
+	if errors.As(err, &ce) {
+		return ce
+	}
+	st := status.Convert(err)
+	ce = connect.NewError(connect.Code(st.Code()), errors.New(st.Message()))
+	for _, d := range st.Proto().GetDetails() {
+		ed, err := connect.NewErrorDetail(d)
+		if err != nil {
+			continue
+		}
+		ce.AddDetail(ed)
+	}
+	return ce
+}
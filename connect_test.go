@@ -0,0 +1,217 @@
+package grpcstub
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+
+	"connectrpc.com/connect"
+	"github.com/k1LoW/grpcstub/testdata/routeguide"
+	"golang.org/x/net/http2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestConnectProtocols(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []connect.ClientOption
+	}{
+		{"connect+proto", nil},
+		{"connect+json", []connect.ClientOption{connect.WithProtoJSON()}},
+		{"grpc-web", []connect.ClientOption{connect.WithGRPCWeb()}},
+		{"grpc", []connect.ClientOption{connect.WithGRPC()}},
+	}
+	ctx := context.Background()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := NewServer(t, "testdata/route_guide.proto", EnableConnect())
+			t.Cleanup(func() {
+				ts.Close()
+			})
+			ts.Method("GetFeature").Header("session", "xxx").Response(map[string]any{"name": "hello"})
+			ts.Method("ListFeatures").Response(map[string]any{"name": "hello"}).Response(map[string]any{"name": "world"})
+			u := fmt.Sprintf("http://%s", ts.Addr())
+
+			{
+				client := connect.NewClient[routeguide.Point, routeguide.Feature](h2cClient(), u+"/routeguide.RouteGuide/GetFeature", tt.opts...)
+				req := connect.NewRequest(&routeguide.Point{Latitude: 10})
+				req.Header().Set("x-tenant", "alice")
+				res, err := client.CallUnary(ctx, req)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if want := "hello"; res.Msg.GetName() != want {
+					t.Errorf("got %v\nwant %v", res.Msg.GetName(), want)
+				}
+				if got, want := res.Header().Get("session"), "xxx"; got != want {
+					t.Errorf("got %v\nwant %v", got, want)
+				}
+			}
+			{
+				client := connect.NewClient[routeguide.Rectangle, routeguide.Feature](h2cClient(), u+"/routeguide.RouteGuide/ListFeatures", tt.opts...)
+				stream, err := client.CallServerStream(ctx, connect.NewRequest(&routeguide.Rectangle{}))
+				if err != nil {
+					t.Fatal(err)
+				}
+				var names []string
+				for stream.Receive() {
+					names = append(names, stream.Msg().GetName())
+				}
+				if err := stream.Err(); err != nil {
+					t.Fatal(err)
+				}
+				if got, want := fmt.Sprint(names), "[hello world]"; got != want {
+					t.Errorf("got %v\nwant %v", got, want)
+				}
+			}
+
+			{
+				got := len(ts.Requests())
+				if want := 2; got != want {
+					t.Errorf("got %v\nwant %v", got, want)
+				}
+				if got, want := ts.Requests()[0].Headers.Get("x-tenant"), []string{"alice"}; fmt.Sprint(got) != fmt.Sprint(want) {
+					t.Errorf("got %v\nwant %v", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestConnectStreaming(t *testing.T) {
+	ctx := context.Background()
+	ts := NewServer(t, "testdata/route_guide.proto", EnableConnect())
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	ts.Method("RecordRoute").Response(map[string]any{"point_count": 2})
+	ts.Method("RouteChat").Response(map[string]any{"message": "hello"})
+	u := fmt.Sprintf("http://%s", ts.Addr())
+
+	t.Run("client streaming", func(t *testing.T) {
+		client := connect.NewClient[routeguide.Point, routeguide.RouteSummary](h2cClient(), u+"/routeguide.RouteGuide/RecordRoute")
+		stream := client.CallClientStream(ctx)
+		for i := range 2 {
+			if err := stream.Send(&routeguide.Point{Latitude: int32(i)}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		res, err := stream.CloseAndReceive()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := int32(2); res.Msg.GetPointCount() != want {
+			t.Errorf("got %v\nwant %v", res.Msg.GetPointCount(), want)
+		}
+	})
+
+	t.Run("bidirectional streaming", func(t *testing.T) {
+		client := connect.NewClient[routeguide.RouteNote, routeguide.RouteNote](h2cClient(), u+"/routeguide.RouteGuide/RouteChat")
+		stream := client.CallBidiStream(ctx)
+		for range 2 {
+			if err := stream.Send(&routeguide.RouteNote{Message: "hi"}); err != nil {
+				t.Fatal(err)
+			}
+			res, err := stream.Receive()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := "hello"; res.GetMessage() != want {
+				t.Errorf("got %v\nwant %v", res.GetMessage(), want)
+			}
+		}
+		if err := stream.CloseRequest(); err != nil {
+			t.Fatal(err)
+		}
+		if err := stream.CloseResponse(); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestConnectError(t *testing.T) {
+	ctx := context.Background()
+	ts := NewServer(t, "testdata/route_guide.proto", EnableConnect())
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	ts.Method("GetFeature").Status(status.New(codes.Unavailable, "unavailable"))
+	u := fmt.Sprintf("http://%s", ts.Addr())
+
+	// HTTP/1.1
+	client := connect.NewClient[routeguide.Point, routeguide.Feature](http.DefaultClient, u+"/routeguide.RouteGuide/GetFeature")
+	_, err := client.CallUnary(ctx, connect.NewRequest(&routeguide.Point{}))
+	var ce *connect.Error
+	if !errors.As(err, &ce) {
+		t.Fatalf("got %v\nwant *connect.Error", err)
+	}
+	if got, want := ce.Code(), connect.CodeUnavailable; got != want {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+	if got, want := ce.Message(), "unavailable"; got != want {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+}
+
+func TestConnectWithGRPCClient(t *testing.T) {
+	ctx := context.Background()
+	cacert, err := os.ReadFile("testdata/cacert.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := os.ReadFile("testdata/cert.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := os.ReadFile("testdata/key.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		ts   func(t *testing.T) *Server
+	}{
+		{"h2c", func(t *testing.T) *Server {
+			return NewServer(t, "testdata/route_guide.proto", EnableConnect())
+		}},
+		{"TLS", func(t *testing.T) *Server {
+			return NewTLSServer(t, "testdata/route_guide.proto", cacert, cert, key, EnableConnect())
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := tt.ts(t)
+			t.Cleanup(func() {
+				ts.Close()
+			})
+			ts.Method("GetFeature").Response(map[string]any{"name": "hello"})
+
+			client := routeguide.NewRouteGuideClient(ts.Conn())
+			res, err := client.GetFeature(ctx, &routeguide.Point{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := "hello"; res.Name != want {
+				t.Errorf("got %v\nwant %v", res.Name, want)
+			}
+		})
+	}
+}
+
+func h2cClient() *http.Client {
+	return &http.Client{
+		Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			},
+		},
+	}
+}
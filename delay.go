@@ -0,0 +1,52 @@
+package grpcstub
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"google.golang.org/grpc/status"
+)
+
+type delay struct {
+	min time.Duration
+	max time.Duration
+}
+
+func (d delay) duration() time.Duration {
+	if d.max <= d.min {
+		return d.min
+	}
+	return d.min + time.Duration(rand.Int63n(int64(d.max-d.min)+1)) //nolint:gosec
+}
+
+// Delay set the delay before returning the response.
+func (m *matcher) Delay(d time.Duration) *matcher {
+	return m.DelayRange(d, d)
+}
+
+// DelayRange set the random delay between min and max before returning the response.
+func (m *matcher) DelayRange(min, max time.Duration) *matcher {
+	if min < 0 || max < min {
+		m.t.Fatalf("invalid delay range: %s-%s", min, max)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.delay = delay{min: min, max: max}
+	return m
+}
+
+// sleep waits for d, or returns the status error of the context when it is done before.
+func sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return status.FromContextError(ctx.Err()).Err()
+	case <-t.C:
+		return nil
+	}
+}
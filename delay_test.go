@@ -0,0 +1,107 @@
+package grpcstub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/k1LoW/grpcstub/testdata/routeguide"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestDelay(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []Option
+		fn   func(m *matcher) *matcher
+		min  time.Duration
+	}{
+		{"matcher", nil, func(m *matcher) *matcher { return m.Delay(100 * time.Millisecond) }, 100 * time.Millisecond},
+		{"matcher range", nil, func(m *matcher) *matcher { return m.DelayRange(50*time.Millisecond, 100*time.Millisecond) }, 50 * time.Millisecond},
+		{"server", []Option{Delay(100 * time.Millisecond)}, func(m *matcher) *matcher { return m }, 100 * time.Millisecond},
+		{"server and matcher", []Option{Delay(50 * time.Millisecond)}, func(m *matcher) *matcher { return m.Delay(50 * time.Millisecond) }, 100 * time.Millisecond},
+	}
+	ctx := context.Background()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := NewServer(t, "testdata/route_guide.proto", tt.opts...)
+			t.Cleanup(func() {
+				ts.Close()
+			})
+			tt.fn(ts.Method("GetFeature")).Response(map[string]any{"name": "hello"})
+
+			client := routeguide.NewRouteGuideClient(ts.Conn())
+			start := time.Now()
+			if _, err := client.GetFeature(ctx, &routeguide.Point{}); err != nil {
+				t.Fatal(err)
+			}
+			if got := time.Since(start); got < tt.min {
+				t.Errorf("got %v\nwant >= %v", got, tt.min)
+			}
+		})
+	}
+}
+
+func TestDelayDeadlineExceeded(t *testing.T) {
+	ts := NewServer(t, "testdata/route_guide.proto")
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	ts.Method("GetFeature").Delay(time.Second).Response(map[string]any{"name": "hello"})
+	ts.Method("ListFeatures").Delay(time.Second).Response(map[string]any{"name": "hello"})
+
+	client := routeguide.NewRouteGuideClient(ts.Conn())
+	t.Run("Unary", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		_, err := client.GetFeature(ctx, &routeguide.Point{})
+		if got := status.Code(err); got != codes.DeadlineExceeded {
+			t.Errorf("got %v\nwant %v", got, codes.DeadlineExceeded)
+		}
+	})
+	t.Run("ServerStreaming", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		stream, err := client.ListFeatures(ctx, &routeguide.Rectangle{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = stream.Recv()
+		if got := status.Code(err); got != codes.DeadlineExceeded {
+			t.Errorf("got %v\nwant %v", got, codes.DeadlineExceeded)
+		}
+	})
+}
+
+func TestDelayRangeOption(t *testing.T) {
+	c := &config{}
+	if err := DelayRange(time.Second, time.Millisecond)(c); err == nil {
+		t.Error("want error")
+	}
+}
+
+func TestDelayRangeInvalid(t *testing.T) {
+	ts := NewServer(t, "testdata/route_guide.proto")
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	tests := []struct {
+		name     string
+		min, max time.Duration
+	}{
+		{"negative", -time.Second, time.Second},
+		{"min greater than max", time.Second, time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tb := &fatalTB{TB: t}
+			m := ts.Method("GetFeature")
+			m.t = tb
+			m.DelayRange(tt.min, tt.max)
+			if len(tb.fatals) == 0 {
+				t.Error("want fatal")
+			}
+		})
+	}
+}
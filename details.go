@@ -0,0 +1,56 @@
+package grpcstub
+
+import (
+	"encoding/json"
+	"fmt"
+
+	_ "google.golang.org/genproto/googleapis/rpc/errdetails" // register google.rpc.* error details
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// StatusWithDetails set handler which return response with status with error details.
+// Each of details is either [proto.Message] (e.g. *errdetails.BadRequest) or Message with "@type" (e.g. {"@type": "type.googleapis.com/google.rpc.RetryInfo", "retry_delay": "1s"}) resolved through the loaded descriptors.
+func (m *matcher) StatusWithDetails(c codes.Code, msg string, details ...any) *matcher {
+	st, err := m.s.statusWithDetails(c, msg, details...)
+	if err != nil {
+		m.t.Fatalf("failed to create status: %v", err)
+	}
+	return m.Status(st)
+}
+
+func (s *Server) statusWithDetails(c codes.Code, msg string, details ...any) (*status.Status, error) {
+	sp := status.New(c, msg).Proto()
+	for _, d := range details {
+		a, err := s.convertDetail(d)
+		if err != nil {
+			return nil, err
+		}
+		sp.Details = append(sp.Details, a)
+	}
+	return status.FromProto(sp), nil
+}
+
+func (s *Server) convertDetail(d any) (*anypb.Any, error) {
+	switch v := d.(type) {
+	case *anypb.Any:
+		return v, nil
+	case protoreflect.ProtoMessage:
+		return anypb.New(v)
+	case Message, map[string]any:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		a := &anypb.Any{}
+		if err := (protojson.UnmarshalOptions{Resolver: s.resolver()}).Unmarshal(b, a); err != nil {
+			return nil, fmt.Errorf("failed to convert detail: %w", err)
+		}
+		return a, nil
+	default:
+		return nil, fmt.Errorf("unsupported detail type: %T", d)
+	}
+}
@@ -0,0 +1,75 @@
+package grpcstub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/k1LoW/grpcstub/testdata/routeguide"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/testing/protocmp"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestStatusWithDetails(t *testing.T) {
+	ctx := context.Background()
+	ts := NewServer(t, "testdata/route_guide.proto")
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	ts.Method("GetFeature").StatusWithDetails(codes.InvalidArgument, "invalid argument",
+		&errdetails.BadRequest{
+			FieldViolations: []*errdetails.BadRequest_FieldViolation{
+				{Field: "latitude", Description: "out of range"},
+			},
+		},
+		map[string]any{
+			"@type":       "type.googleapis.com/google.rpc.RetryInfo",
+			"retry_delay": "1s",
+		},
+		Message{
+			"@type":     "type.googleapis.com/routeguide.Point",
+			"latitude":  10,
+			"longitude": 13,
+		},
+	)
+
+	client := routeguide.NewRouteGuideClient(ts.Conn())
+	_, err := client.GetFeature(ctx, &routeguide.Point{})
+	s, ok := status.FromError(err)
+	if !ok {
+		t.Fatal("want status.Status")
+	}
+	if got := s.Code(); got != codes.InvalidArgument {
+		t.Errorf("got %v\nwant %v", got, codes.InvalidArgument)
+	}
+	got := s.Details()
+	want := []any{
+		&errdetails.BadRequest{
+			FieldViolations: []*errdetails.BadRequest_FieldViolation{
+				{Field: "latitude", Description: "out of range"},
+			},
+		},
+		&errdetails.RetryInfo{RetryDelay: durationpb.New(time.Second)},
+		&routeguide.Point{Latitude: 10, Longitude: 13},
+	}
+	if diff := cmp.Diff(got, want, protocmp.Transform()); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestStatusWithDetailsUnknownType(t *testing.T) {
+	ts := NewServer(t, "testdata/route_guide.proto")
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	if _, err := ts.statusWithDetails(codes.Internal, "internal", map[string]any{"@type": "type.googleapis.com/unknown.Type"}); err == nil {
+		t.Error("want error")
+	}
+	if _, err := ts.statusWithDetails(codes.Internal, "internal", "detail"); err == nil {
+		t.Error("want error")
+	}
+}
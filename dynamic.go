@@ -144,6 +144,8 @@ func generateDynamicMessage(gs generators, req *Request, m protoreflect.MessageD
 func (s *Server) ResponseDynamic(opts ...GeneratorOption) *matcher {
 	m := &matcher{
 		matchFuncs: []matchFunc{func(_ *Request) bool { return true }},
+		s:          s,
+		t:          s.t,
 	}
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -0,0 +1,134 @@
+package grpcstub
+
+import (
+	"fmt"
+	"runtime"
+)
+
+type expectation struct {
+	m      *matcher
+	min    int
+	max    int // -1 means unlimited
+	caller string
+}
+
+// Expect returns expectation of the number of calls to the matcher.
+// Unmet expectations are reported by (*Server).AssertExpectations.
+func (m *matcher) Expect() *expectation {
+	var caller string
+	if _, file, line, ok := runtime.Caller(1); ok {
+		caller = fmt.Sprintf("%s:%d", file, line)
+	}
+	return &expectation{m: m, max: -1, caller: caller}
+}
+
+// Times expect the matcher to be called exactly n times.
+func (e *expectation) Times(n int) *matcher {
+	return e.set(n, n)
+}
+
+// Once expect the matcher to be called exactly once.
+func (e *expectation) Once() *matcher {
+	return e.set(1, 1)
+}
+
+// Never expect the matcher never to be called.
+func (e *expectation) Never() *matcher {
+	return e.set(0, 0)
+}
+
+// AtLeast expect the matcher to be called at least n times.
+func (e *expectation) AtLeast(n int) *matcher {
+	return e.set(n, -1)
+}
+
+// AtMost expect the matcher to be called at most n times.
+func (e *expectation) AtMost(n int) *matcher {
+	return e.set(0, n)
+}
+
+func (e *expectation) set(least, most int) *matcher {
+	e.min = least
+	e.max = most
+	e.m.mu.Lock()
+	defer e.m.mu.Unlock()
+	e.m.expectation = e
+	return e.m
+}
+
+func (e *expectation) String() string {
+	var s string
+	switch {
+	case e.min == e.max:
+		s = fmt.Sprintf("exactly %d times", e.min)
+	case e.max < 0:
+		s = fmt.Sprintf("at least %d times", e.min)
+	default:
+		s = fmt.Sprintf("at most %d times", e.max)
+	}
+	if e.caller != "" {
+		s = fmt.Sprintf("%s (%s)", s, e.caller)
+	}
+	return s
+}
+
+func (e *expectation) met(calls int) bool {
+	if calls < e.min {
+		return false
+	}
+	if e.max >= 0 && calls > e.max {
+		return false
+	}
+	return true
+}
+
+// AssertExpectations asserts that all expectations of matchers are met and that there are no unmatched requests.
+// It is called automatically at the cleanup of the test when at least one expectation is set or FailOnUnmatchedRequests is enabled.
+func (s *Server) AssertExpectations() bool {
+	s.t.Helper()
+	ok := true
+	s.mu.RLock()
+	matchers := s.matchers
+	unmatched := s.unmatchedRequests
+	s.mu.RUnlock()
+	for _, m := range matchers {
+		m.mu.RLock()
+		e := m.expectation
+		calls := m.calls
+		requests := m.requests
+		m.mu.RUnlock()
+		if e == nil || e.met(calls) {
+			continue
+		}
+		ok = false
+		msg := fmt.Sprintf("unmet expectation: want to be called %s, but called %d times", e, calls)
+		for _, r := range requests {
+			msg += "\n" + r.String()
+		}
+		s.t.Error(msg)
+	}
+	for _, r := range unmatched {
+		ok = false
+		s.t.Errorf("unmatched request:\n%s", r.String())
+	}
+	return ok
+}
+
+// assertAtCleanup reports whether AssertExpectations should be called at the cleanup of the test.
+func (s *Server) assertAtCleanup() bool {
+	return s.failOnUnmatched || s.hasExpectations()
+}
+
+func (s *Server) hasExpectations() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, m := range s.matchers {
+		m.mu.RLock()
+		e := m.expectation
+		m.mu.RUnlock()
+		if e != nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package grpcstub
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/k1LoW/grpcstub/testdata/routeguide"
+)
+
+type cleanupTB struct {
+	testing.TB
+	errors   []string
+	cleanups []func()
+}
+
+func (tb *cleanupTB) Error(args ...any) {
+	tb.errors = append(tb.errors, fmt.Sprint(args...))
+}
+
+func (tb *cleanupTB) Errorf(format string, args ...any) {
+	tb.errors = append(tb.errors, fmt.Sprintf(format, args...))
+}
+
+func (tb *cleanupTB) Cleanup(fn func()) {
+	tb.cleanups = append(tb.cleanups, fn)
+}
+
+func (tb *cleanupTB) runCleanups() {
+	for i := len(tb.cleanups) - 1; i >= 0; i-- {
+		tb.cleanups[i]()
+	}
+}
+
+// fatalTB records the fatal errors instead of stopping the test.
+type fatalTB struct {
+	TB
+	fatals []string
+}
+
+func (tb *fatalTB) Fatal(args ...any) {
+	tb.fatals = append(tb.fatals, fmt.Sprint(args...))
+}
+
+func (tb *fatalTB) Fatalf(format string, args ...any) {
+	tb.fatals = append(tb.fatals, fmt.Sprintf(format, args...))
+}
+
+func TestExpect(t *testing.T) {
+	tests := []struct {
+		name       string
+		fn         func(ts *Server)
+		calls      int
+		wantErrors []string
+	}{
+		{
+			"Times met",
+			func(ts *Server) { ts.Method("GetFeature").Expect().Times(2).Response(map[string]any{"name": "hello"}) },
+			2,
+			nil,
+		},
+		{
+			"Times unmet",
+			func(ts *Server) { ts.Method("GetFeature").Expect().Times(2).Response(map[string]any{"name": "hello"}) },
+			1,
+			[]string{"unmet expectation: want to be called exactly 2 times"},
+		},
+		{
+			"Never unmet",
+			func(ts *Server) {
+				ts.Method("GetFeature").Expect().Never()
+				ts.Method("GetFeature").Response(map[string]any{"name": "hello"})
+			},
+			1,
+			[]string{"unmet expectation: want to be called exactly 0 times"},
+		},
+		{
+			"AtLeast met",
+			func(ts *Server) {
+				ts.Method("GetFeature").Expect().AtLeast(1).Response(map[string]any{"name": "hello"})
+			},
+			3,
+			nil,
+		},
+		{
+			"Unmatched request",
+			func(ts *Server) { ts.Method("ListFeatures").Expect().Never() },
+			1,
+			[]string{"unmatched request:\nrouteguide.RouteGuide/GetFeature"},
+		},
+		{
+			"No expectations",
+			func(ts *Server) {},
+			1,
+			nil,
+		},
+	}
+	ctx := context.Background()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tb := &cleanupTB{TB: t}
+			ts := NewServer(tb, "testdata/route_guide.proto")
+			tt.fn(ts)
+			client := routeguide.NewRouteGuideClient(ts.Conn())
+			for i := 0; i < tt.calls; i++ {
+				_, _ = client.GetFeature(ctx, &routeguide.Point{})
+			}
+			ts.Close()
+			tb.runCleanups()
+			if len(tb.errors) != len(tt.wantErrors) {
+				t.Fatalf("got %v\nwant %v", tb.errors, tt.wantErrors)
+			}
+			for i, want := range tt.wantErrors {
+				if !strings.HasPrefix(tb.errors[i], want) {
+					t.Errorf("got %v\nwant prefix %v", tb.errors[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestFailOnUnmatchedRequests(t *testing.T) {
+	tb := &cleanupTB{TB: t}
+	ts := NewServer(tb, "testdata/route_guide.proto", FailOnUnmatchedRequests())
+	ts.Method("ListFeatures").Response(map[string]any{"name": "hello"})
+	client := routeguide.NewRouteGuideClient(ts.Conn())
+	_, _ = client.GetFeature(context.Background(), &routeguide.Point{})
+	ts.Close()
+	tb.runCleanups()
+	if len(tb.errors) != 1 {
+		t.Fatalf("got %v\nwant 1 error", tb.errors)
+	}
+	if want := "unmatched request:\nrouteguide.RouteGuide/GetFeature"; !strings.HasPrefix(tb.errors[0], want) {
+		t.Errorf("got %v\nwant prefix %v", tb.errors[0], want)
+	}
+}
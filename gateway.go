@@ -0,0 +1,535 @@
+package grpcstub
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	protov2 "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+const httpRuleExtensionName = "google.api.http"
+
+// httpRule is google.api.HttpRule decoded from the method options.
+type httpRule struct {
+	Get    string `json:"get"`
+	Put    string `json:"put"`
+	Post   string `json:"post"`
+	Delete string `json:"delete"`
+	Patch  string `json:"patch"`
+	Custom *struct {
+		Kind string `json:"kind"`
+		Path string `json:"path"`
+	} `json:"custom"`
+	Body               string      `json:"body"`
+	ResponseBody       string      `json:"response_body"`
+	AdditionalBindings []*httpRule `json:"additional_bindings"`
+}
+
+// httpRoute is a pair of HTTP method and path template mapped to the gRPC method.
+type httpRoute struct {
+	method       string
+	re           *regexp.Regexp
+	vars         []string
+	body         string
+	responseBody string
+	literals     int
+	wildcards    int
+	md           protoreflect.MethodDescriptor
+	handler      func(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error)
+}
+
+// httpRoutes returns the routes of unary methods annotated with google.api.http.
+// The routes are sorted by specificity so that a literal route is not shadowed by a variable one.
+func (s *Server) httpRoutes() ([]*httpRoute, error) {
+	var routes []*httpRoute
+	for _, fd := range s.fds {
+		for i := 0; i < fd.Services().Len(); i++ {
+			sd := fd.Services().Get(i)
+			for j := 0; j < sd.Methods().Len(); j++ {
+				md := sd.Methods().Get(j)
+				if md.IsStreamingClient() || md.IsStreamingServer() {
+					continue
+				}
+				rule, err := s.httpRuleOf(md)
+				if err != nil {
+					return nil, err
+				}
+				if rule == nil {
+					continue
+				}
+				handler := s.createUnaryHandler(md)
+				for _, r := range append([]*httpRule{rule}, rule.AdditionalBindings...) {
+					rt, err := newHTTPRoute(r, md)
+					if err != nil {
+						return nil, fmt.Errorf("invalid google.api.http of %s: %w", md.FullName(), err)
+					}
+					rt.handler = handler
+					routes = append(routes, rt)
+				}
+			}
+		}
+	}
+	sort.SliceStable(routes, func(i, j int) bool {
+		if routes[i].literals != routes[j].literals {
+			return routes[i].literals > routes[j].literals
+		}
+		return routes[i].wildcards < routes[j].wildcards
+	})
+	return routes, nil
+}
+
+// httpRuleOf returns google.api.http option of the method, or nil when the method has no option.
+func (s *Server) httpRuleOf(md protoreflect.MethodDescriptor) (*httpRule, error) {
+	m, err := s.methodOption(md, httpRuleExtensionName)
+	if err != nil || m == nil {
+		return nil, err
+	}
+	jb, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	rule := &httpRule{}
+	if err := json.Unmarshal(jb, rule); err != nil {
+		return nil, err
+	}
+	return rule, nil
+}
+
+// methodOption returns the message option of the method resolved by the compiled descriptors, or nil when the method has no option.
+func (s *Server) methodOption(md protoreflect.MethodDescriptor, name protoreflect.FullName) (Message, error) {
+	xt, err := s.resolver().FindExtensionByName(name)
+	if err != nil {
+		return nil, nil //nolint:nilerr
+	}
+	// Re-parse the options so that the extension is resolved by the compiled descriptors.
+	b, err := protov2.Marshal(md.Options())
+	if err != nil {
+		return nil, err
+	}
+	opts := &descriptorpb.MethodOptions{}
+	if err := (protov2.UnmarshalOptions{Resolver: s.resolver()}).Unmarshal(b, opts); err != nil {
+		return nil, err
+	}
+	if !protov2.HasExtension(opts, xt) {
+		return nil, nil
+	}
+	v, ok := protov2.GetExtension(opts, xt).(protoreflect.ProtoMessage)
+	if !ok {
+		return nil, fmt.Errorf("invalid %s option of %s", name, md.FullName())
+	}
+	return MarshalProtoMessage(v)
+}
+
+func newHTTPRoute(r *httpRule, md protoreflect.MethodDescriptor) (*httpRoute, error) {
+	rt := &httpRoute{body: r.Body, responseBody: r.ResponseBody, md: md}
+	var tmpl string
+	switch {
+	case r.Get != "":
+		rt.method, tmpl = http.MethodGet, r.Get
+	case r.Put != "":
+		rt.method, tmpl = http.MethodPut, r.Put
+	case r.Post != "":
+		rt.method, tmpl = http.MethodPost, r.Post
+	case r.Delete != "":
+		rt.method, tmpl = http.MethodDelete, r.Delete
+	case r.Patch != "":
+		rt.method, tmpl = http.MethodPatch, r.Patch
+	case r.Custom != nil:
+		rt.method, tmpl = r.Custom.Kind, r.Custom.Path
+	default:
+		return nil, fmt.Errorf("no pattern")
+	}
+	re, vars, err := compilePathTemplate(tmpl)
+	if err != nil {
+		return nil, err
+	}
+	rt.re = re
+	rt.vars = vars
+	rt.literals, rt.wildcards = templateSpecificity(tmpl)
+	return rt, nil
+}
+
+// templateSpecificity returns the number of literal segments (including the verb) and the weight of wildcards in the path template.
+// "**" weighs more than "*" because it matches any number of segments.
+func templateSpecificity(tmpl string) (literals, wildcards int) {
+	segs, err := splitPathTemplate(tmpl[1:])
+	if err != nil {
+		return 0, 0
+	}
+	if i := strings.LastIndex(tmpl, ":"); i > strings.LastIndex(tmpl, "/") && i > strings.LastIndex(tmpl, "}") {
+		literals++
+	}
+	for _, seg := range segs {
+		if strings.HasPrefix(seg, "{") {
+			_, pattern, ok := strings.Cut(strings.Trim(seg, "{}"), "=")
+			if !ok {
+				pattern = "*"
+			}
+			seg = pattern
+		}
+		for _, s := range strings.Split(seg, "/") {
+			switch s {
+			case "*":
+				wildcards++
+			case "**":
+				wildcards += 2
+			default:
+				literals++
+			}
+		}
+	}
+	return literals, wildcards
+}
+
+// compilePathTemplate compiles the path template (e.g. "/v1/{name=shelves/*/books/*}:publish") to the regular expression
+// and returns it with the names of the variables in the order of the capture groups.
+func compilePathTemplate(tmpl string) (*regexp.Regexp, []string, error) {
+	if !strings.HasPrefix(tmpl, "/") {
+		return nil, nil, fmt.Errorf("path template must start with '/': %s", tmpl)
+	}
+	path, verb := tmpl, ""
+	if i := strings.LastIndex(tmpl, ":"); i > strings.LastIndex(tmpl, "/") && i > strings.LastIndex(tmpl, "}") {
+		path, verb = tmpl[:i], tmpl[i+1:]
+	}
+	segs, err := splitPathTemplate(path[1:])
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", err, tmpl)
+	}
+	var (
+		vars []string
+		b    strings.Builder
+	)
+	b.WriteString("^")
+	for _, seg := range segs {
+		b.WriteString("/")
+		if !strings.HasPrefix(seg, "{") {
+			b.WriteString(segmentsPattern([]string{seg}))
+			continue
+		}
+		if !strings.HasSuffix(seg, "}") {
+			return nil, nil, fmt.Errorf("invalid variable in path template: %s", tmpl)
+		}
+		name, pattern, ok := strings.Cut(seg[1:len(seg)-1], "=")
+		if !ok {
+			pattern = "*"
+		}
+		if name == "" || pattern == "" {
+			return nil, nil, fmt.Errorf("invalid variable in path template: %s", tmpl)
+		}
+		vars = append(vars, name)
+		b.WriteString("(" + segmentsPattern(strings.Split(pattern, "/")) + ")")
+	}
+	if verb != "" {
+		b.WriteString(":" + regexp.QuoteMeta(verb))
+	}
+	b.WriteString("$")
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return nil, nil, err
+	}
+	return re, vars, nil
+}
+
+// splitPathTemplate splits the path template by '/' outside of variables.
+func splitPathTemplate(path string) ([]string, error) {
+	var (
+		segs  []string
+		depth int
+		start int
+	)
+	for i, c := range path {
+		switch c {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case '/':
+			if depth == 0 {
+				segs = append(segs, path[start:i])
+				start = i + 1
+			}
+		}
+		if depth < 0 || depth > 1 {
+			return nil, fmt.Errorf("invalid path template")
+		}
+	}
+	if depth != 0 {
+		return nil, fmt.Errorf("invalid path template")
+	}
+	return append(segs, path[start:]), nil
+}
+
+func segmentsPattern(segs []string) string {
+	var patterns []string
+	for _, seg := range segs {
+		switch seg {
+		case "*":
+			patterns = append(patterns, "[^/]+")
+		case "**":
+			patterns = append(patterns, ".*")
+		default:
+			patterns = append(patterns, regexp.QuoteMeta(seg))
+		}
+	}
+	return strings.Join(patterns, "/")
+}
+
+// matchHTTPRoute returns the first route matching the request and the values of the variables.
+func matchHTTPRoute(routes []*httpRoute, r *http.Request) (*httpRoute, []string) {
+	for _, rt := range routes {
+		if rt.method != r.Method {
+			continue
+		}
+		if m := rt.re.FindStringSubmatch(r.URL.Path); m != nil {
+			return rt, m[1:]
+		}
+	}
+	return nil, nil
+}
+
+// serveHTTPRoute transcodes the HTTP/JSON request to the gRPC request, dispatches it to the matchers and writes the response as JSON.
+func (s *Server) serveHTTPRoute(w http.ResponseWriter, r *http.Request, rt *httpRoute, values []string) {
+	in, err := transcodeRequest(r, rt, values)
+	if err != nil {
+		s.writeHTTPError(w, status.New(codes.InvalidArgument, err.Error()))
+		return
+	}
+	req := dynamicpb.NewMessage(rt.md.Input())
+	if err := UnmarshalProtoMessage(in, req); err != nil {
+		s.writeHTTPError(w, status.New(codes.InvalidArgument, err.Error()))
+		return
+	}
+	ts := &connectTransportStream{method: fmt.Sprintf("/%s/%s", rt.md.Parent().FullName(), rt.md.Name()), header: metadata.MD{}, trailer: metadata.MD{}}
+	ctx := grpc.NewContextWithServerTransportStream(incomingContext(r.Context(), r.Header), ts)
+	out, err := rt.handler(nil, ctx, func(m any) error {
+		protov2.Merge(m.(protov2.Message), req)
+		return nil
+	}, nil)
+	appendHeader(w.Header(), ts.header)
+	appendHeader(w.Header(), ts.trailer)
+	if err != nil {
+		s.writeHTTPError(w, status.Convert(err))
+		return
+	}
+	b, err := (protojson.MarshalOptions{EmitUnpopulated: true, Resolver: s.resolver()}).Marshal(out.(protov2.Message))
+	if err != nil {
+		s.writeHTTPError(w, status.New(codes.Internal, err.Error()))
+		return
+	}
+	if rt.responseBody != "" {
+		fd := rt.md.Output().Fields().ByName(protoreflect.Name(rt.responseBody))
+		if fd == nil {
+			s.writeHTTPError(w, status.Newf(codes.Internal, "response_body field not found: %s", rt.responseBody))
+			return
+		}
+		var res map[string]json.RawMessage
+		if err := json.Unmarshal(b, &res); err != nil {
+			s.writeHTTPError(w, status.New(codes.Internal, err.Error()))
+			return
+		}
+		b = res[fd.JSONName()]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(b)
+}
+
+// transcodeRequest builds the request message from the path variables, the body and the query parameters.
+// The query parameters never overwrite the fields bound by the path variables or the body.
+func transcodeRequest(r *http.Request, rt *httpRoute, values []string) (Message, error) {
+	in := Message{}
+	var bound []string
+	if rt.body != "" {
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		if len(bytes.TrimSpace(b)) > 0 {
+			var v any
+			if err := json.Unmarshal(b, &v); err != nil {
+				return nil, fmt.Errorf("invalid body: %w", err)
+			}
+			if rt.body == "*" {
+				m, ok := v.(map[string]any)
+				if !ok {
+					return nil, fmt.Errorf("invalid body: %s", string(b))
+				}
+				in = m
+			} else {
+				fds, err := lookupFieldDescriptors(rt.md.Input(), rt.body)
+				if err != nil {
+					return nil, err
+				}
+				setMessageField(in, fds, v)
+			}
+		}
+		if rt.body != "*" {
+			// The body field is bound even when the body is empty.
+			bound = append(bound, rt.body)
+		}
+	}
+	for i, name := range rt.vars {
+		fds, err := lookupFieldDescriptors(rt.md.Input(), name)
+		if err != nil {
+			return nil, err
+		}
+		v, err := parseFieldValue(fds[len(fds)-1], values[i])
+		if err != nil {
+			return nil, err
+		}
+		setMessageField(in, fds, v)
+		bound = append(bound, fieldPath(fds))
+	}
+	if rt.body == "*" {
+		return in, nil
+	}
+	for k, vs := range r.URL.Query() {
+		fds, err := lookupFieldDescriptors(rt.md.Input(), k)
+		if err != nil {
+			// Unknown query parameters are ignored.
+			continue
+		}
+		if isBoundFieldPath(bound, fieldPath(fds)) {
+			continue
+		}
+		fd := fds[len(fds)-1]
+		if fd.IsList() {
+			var l []any
+			for _, s := range vs {
+				v, err := parseFieldValue(fd, s)
+				if err != nil {
+					return nil, err
+				}
+				l = append(l, v)
+			}
+			setMessageField(in, fds, l)
+			continue
+		}
+		v, err := parseFieldValue(fd, vs[0])
+		if err != nil {
+			return nil, err
+		}
+		setMessageField(in, fds, v)
+	}
+	return in, nil
+}
+
+// lookupFieldDescriptors returns the field descriptors of the dotted field path (e.g. "book.name").
+// Each field is looked up by the proto name or the JSON name.
+func lookupFieldDescriptors(md protoreflect.MessageDescriptor, path string) ([]protoreflect.FieldDescriptor, error) {
+	var fds []protoreflect.FieldDescriptor
+	for _, name := range strings.Split(path, ".") {
+		if md == nil {
+			return nil, fmt.Errorf("field not found: %s", path)
+		}
+		fd := md.Fields().ByName(protoreflect.Name(name))
+		if fd == nil {
+			fd = md.Fields().ByJSONName(name)
+		}
+		if fd == nil {
+			return nil, fmt.Errorf("field not found: %s", path)
+		}
+		fds = append(fds, fd)
+		md = fd.Message()
+	}
+	return fds, nil
+}
+
+// fieldPath returns the dotted field path of the field descriptors in proto names.
+func fieldPath(fds []protoreflect.FieldDescriptor) string {
+	names := make([]string, len(fds))
+	for i, fd := range fds {
+		names[i] = string(fd.Name())
+	}
+	return strings.Join(names, ".")
+}
+
+// isBoundFieldPath reports whether the field path is one of the bound paths or nested under one of them.
+func isBoundFieldPath(bound []string, path string) bool {
+	for _, b := range bound {
+		if path == b || strings.HasPrefix(path, b+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// parseFieldValue converts the string in the path or the query to the value accepted by protojson.
+func parseFieldValue(fd protoreflect.FieldDescriptor, s string) (any, error) {
+	if fd.Kind() == protoreflect.BoolKind {
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value for %s: %s", fd.Name(), s)
+		}
+		return b, nil
+	}
+	// protojson accepts numbers, enums and well-known types as strings.
+	return s, nil
+}
+
+func setMessageField(m map[string]any, fds []protoreflect.FieldDescriptor, v any) {
+	for _, fd := range fds[:len(fds)-1] {
+		child, ok := m[string(fd.Name())].(map[string]any)
+		if !ok {
+			child = map[string]any{}
+			m[string(fd.Name())] = child
+		}
+		m = child
+	}
+	m[string(fds[len(fds)-1].Name())] = v
+}
+
+func (s *Server) writeHTTPError(w http.ResponseWriter, st *status.Status) {
+	b, err := (protojson.MarshalOptions{Resolver: s.resolver()}).Marshal(st.Proto())
+	if err != nil {
+		b = []byte(fmt.Sprintf(`{"code":%d,"message":%q}`, codes.Internal, err.Error()))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatusFromCode(st.Code()))
+	_, _ = w.Write(b)
+}
+
+// httpStatusFromCode converts the gRPC status code to the HTTP status code in the same way as grpc-gateway.
+func httpStatusFromCode(c codes.Code) int {
+	switch c {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
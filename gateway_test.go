@@ -0,0 +1,230 @@
+package grpcstub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestHTTPGateway(t *testing.T) {
+	ts := NewServer(t, "testdata/gateway/bookstore.proto", ImportPath("testdata/gateway"), EnableHTTPGateway())
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	ts.Method("ListBooks").Response(map[string]any{"books": []any{map[string]any{"name": "shelves/1/books/1", "title": "hello"}}})
+	ts.Method("GetBook").MatchField("name", "shelves/1/books/404").Status(status.New(codes.NotFound, "book not found"))
+	ts.Method("GetBook").Header("x-stub", "grpcstub").Response(map[string]any{"name": "shelves/1/books/1", "title": "hello", "page_count": 100})
+	ts.Method("CreateBook").Response(map[string]any{"name": "shelves/1/books/2", "title": "created"})
+	ts.Method("UpdateBook").Response(map[string]any{"name": "shelves/1/books/1", "title": "updated"})
+	ts.Method("DeleteBook").Response(map[string]any{})
+	ts.Method("GetLatestBook").Response(map[string]any{"name": "shelves/featured/books/latest", "title": "latest"})
+	ts.Method("PublishBook").Response(map[string]any{"book": map[string]any{"name": "shelves/1/books/1", "published": true}})
+
+	tests := []struct {
+		method      string
+		path        string
+		body        string
+		wantStatus  int
+		wantBody    map[string]any
+		wantRequest map[string]any
+	}{
+		{
+			http.MethodGet, "/v1/shelves/1/books?page_size=10&showUnpublished=true&tags=a&tags=b", "",
+			http.StatusOK,
+			map[string]any{"books": []any{map[string]any{"name": "shelves/1/books/1", "title": "hello", "pageCount": "0", "published": false}}},
+			map[string]any{"parent": "shelves/1", "page_size": float64(10), "show_unpublished": true, "tags": []any{"a", "b"}},
+		},
+		{
+			http.MethodGet, "/v1/shelves/1/books/1", "",
+			http.StatusOK,
+			map[string]any{"name": "shelves/1/books/1", "title": "hello", "pageCount": "100", "published": false},
+			map[string]any{"name": "shelves/1/books/1"},
+		},
+		{
+			http.MethodGet, "/v1/shelves/1/books/1?name=shelves/2/books/2", "",
+			http.StatusOK,
+			map[string]any{"name": "shelves/1/books/1", "title": "hello", "pageCount": "100", "published": false},
+			map[string]any{"name": "shelves/1/books/1"},
+		},
+		{
+			http.MethodGet, "/v1/shelves/featured/books/latest", "",
+			http.StatusOK,
+			map[string]any{"name": "shelves/featured/books/latest", "title": "latest", "pageCount": "0", "published": false},
+			map[string]any{},
+		},
+		{
+			http.MethodGet, "/v1/books/1", "",
+			http.StatusOK,
+			map[string]any{"name": "shelves/1/books/1", "title": "hello", "pageCount": "100", "published": false},
+			map[string]any{"name": "1"},
+		},
+		{
+			http.MethodPost, "/v1/shelves/1/books", `{"title": "new"}`,
+			http.StatusOK,
+			map[string]any{"name": "shelves/1/books/2", "title": "created", "pageCount": "0", "published": false},
+			map[string]any{"parent": "shelves/1", "book": map[string]any{"name": "", "title": "new", "page_count": "0", "published": false}},
+		},
+		{
+			http.MethodPost, "/v1/shelves/1/books?parent=shelves/2&book.title=query", `{"title": "new"}`,
+			http.StatusOK,
+			map[string]any{"name": "shelves/1/books/2", "title": "created", "pageCount": "0", "published": false},
+			map[string]any{"parent": "shelves/1", "book": map[string]any{"name": "", "title": "new", "page_count": "0", "published": false}},
+		},
+		{
+			http.MethodPatch, "/v1/shelves/1/books/1", `{"title": "updated", "pageCount": 10}`,
+			http.StatusOK,
+			map[string]any{"name": "shelves/1/books/1", "title": "updated", "pageCount": "0", "published": false},
+			map[string]any{"name": "shelves/1/books/1", "title": "updated", "page_count": "10", "published": false},
+		},
+		{
+			http.MethodDelete, "/v1/shelves/1/books/1", "",
+			http.StatusOK,
+			map[string]any{},
+			map[string]any{"name": "shelves/1/books/1"},
+		},
+		{
+			http.MethodPost, "/v1/shelves/1/books/1:publish", `{}`,
+			http.StatusOK,
+			map[string]any{"name": "shelves/1/books/1", "title": "", "pageCount": "0", "published": true},
+			map[string]any{"name": "shelves/1/books/1"},
+		},
+		{
+			http.MethodGet, "/v1/shelves/1/books/404", "",
+			http.StatusNotFound,
+			map[string]any{"code": float64(codes.NotFound), "message": "book not found"},
+			map[string]any{"name": "shelves/1/books/404"},
+		},
+		{
+			http.MethodPatch, "/v1/shelves/1/books/1", `{"unknown": 1}`,
+			http.StatusBadRequest,
+			nil,
+			nil,
+		},
+	}
+	ctx := context.Background()
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s %s", tt.method, tt.path), func(t *testing.T) {
+			ts.ClearRequests()
+			req, err := http.NewRequestWithContext(ctx, tt.method, fmt.Sprintf("http://%s%s", ts.Addr(), tt.path), strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			res, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+			if res.StatusCode != tt.wantStatus {
+				t.Errorf("got %v\nwant %v", res.StatusCode, tt.wantStatus)
+			}
+			b, err := io.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantBody != nil {
+				got := map[string]any{}
+				if err := json.Unmarshal(b, &got); err != nil {
+					t.Fatal(err)
+				}
+				if diff := cmp.Diff(got, tt.wantBody); diff != "" {
+					t.Error(diff)
+				}
+			}
+			if tt.wantRequest != nil {
+				if len(ts.Requests()) != 1 {
+					t.Fatalf("got %v\nwant %v", len(ts.Requests()), 1)
+				}
+				got := map[string]any(ts.Requests()[0].Message)
+				if diff := cmp.Diff(got, tt.wantRequest, cmpPartialMap(tt.wantRequest)); diff != "" {
+					t.Error(diff)
+				}
+			}
+		})
+	}
+
+	t.Run("header", func(t *testing.T) {
+		res, err := http.Get(fmt.Sprintf("http://%s/v1/books/1", ts.Addr()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if got, want := res.Header.Get("x-stub"), "grpcstub"; got != want {
+			t.Errorf("got %v\nwant %v", got, want)
+		}
+	})
+
+	t.Run("not found route", func(t *testing.T) {
+		res, err := http.Get(fmt.Sprintf("http://%s/v2/books/1", ts.Addr()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		if got, want := res.StatusCode, http.StatusNotFound; got != want {
+			t.Errorf("got %v\nwant %v", got, want)
+		}
+	})
+}
+
+func TestCompilePathTemplate(t *testing.T) {
+	tests := []struct {
+		tmpl     string
+		path     string
+		wantVars []string
+		want     []string
+	}{
+		{"/v1/books", "/v1/books", nil, []string{}},
+		{"/v1/books/{name}", "/v1/books/1", []string{"name"}, []string{"1"}},
+		{"/v1/books/{name}", "/v1/books/1/2", []string{"name"}, nil},
+		{"/v1/{name=shelves/*/books/*}", "/v1/shelves/1/books/2", []string{"name"}, []string{"shelves/1/books/2"}},
+		{"/v1/{book.name=books/*}:publish", "/v1/books/1:publish", []string{"book.name"}, []string{"books/1"}},
+		{"/v1/{name=files/**}", "/v1/files/a/b/c", []string{"name"}, []string{"files/a/b/c"}},
+		{"/v1/shelves/{shelf}/books/{book}", "/v1/shelves/1/books/2", []string{"shelf", "book"}, []string{"1", "2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tmpl, func(t *testing.T) {
+			re, vars, err := compilePathTemplate(tt.tmpl)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(vars, tt.wantVars); diff != "" {
+				t.Error(diff)
+			}
+			var got []string
+			if m := re.FindStringSubmatch(tt.path); m != nil {
+				got = m[1:]
+			}
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+
+	for _, tmpl := range []string{"v1/books", "/v1/{name", "/v1/{=books/*}", "/v1/{a={b}}"} {
+		if _, _, err := compilePathTemplate(tmpl); err == nil {
+			t.Errorf("want error: %s", tmpl)
+		}
+	}
+}
+
+// cmpPartialMap ignores the keys which are not in want.
+func cmpPartialMap(want map[string]any) cmp.Option {
+	return cmp.FilterPath(func(p cmp.Path) bool {
+		if len(p) != 2 {
+			return false
+		}
+		mi, ok := p.Last().(cmp.MapIndex)
+		if !ok {
+			return false
+		}
+		_, ok = want[mi.Key().String()]
+		return !ok
+	}, cmp.Ignore())
+}
@@ -9,10 +9,12 @@ import (
 	"fmt"
 	"io"
 	"net"
+	"net/http"
 	"os"
 	"path/filepath"
 	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"testing"
@@ -29,9 +31,12 @@ import (
 	"google.golang.org/grpc/health"
 	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/reflection"
 	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
 	"google.golang.org/protobuf/encoding/protojson"
+	protov2 "google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/reflect/protoreflect"
 	"google.golang.org/protobuf/reflect/protoregistry"
 	"google.golang.org/protobuf/types/dynamicpb"
@@ -52,6 +57,11 @@ const (
 	HealthCheckService_FLAPPING = "flapping"
 )
 
+const (
+	networkInMemory = "bufconn"
+	bufconnSize     = 1024 * 1024
+)
+
 var _ TB = (testing.TB)(nil)
 
 type TB interface {
@@ -69,6 +79,65 @@ type Request struct {
 	Method  string
 	Headers metadata.MD
 	Message Message
+	// PeerCertificates are the certificates presented by the client in mutual TLS.
+	PeerCertificates []*x509.Certificate
+	// Peer is the client of the request.
+	Peer *peer.Peer
+	// Deadline is the deadline propagated by the client. It is zero when the client sets no deadline.
+	Deadline time.Time
+	// Compression is the name of the compressor of the request message (e.g. "gzip"). It is empty when the message is not compressed.
+	Compression string
+	dm          *dynamicpb.Message
+	ctx         context.Context
+	// stream is the requests of the client stream.
+	stream []*Request
+	// serverStream is the stream to send the response messages of server streaming and bidirectional streaming.
+	serverStream grpc.ServerStream
+}
+
+// DynamicMessage returns the request message as received.
+// It returns nil when the request is not received by the server.
+func (req *Request) DynamicMessage() *dynamicpb.Message {
+	return req.dm
+}
+
+// messageDescriptor returns the descriptor of the request message, or nil when the request is not received by the server.
+func (req *Request) messageDescriptor() protoreflect.MessageDescriptor {
+	if req.dm == nil {
+		return nil
+	}
+	return req.dm.Descriptor()
+}
+
+// Unmarshal unmarshals the request message to dst.
+func (req *Request) Unmarshal(dst protov2.Message) error {
+	if req.dm == nil {
+		return UnmarshalProtoMessage(req.Message, dst)
+	}
+	if got, want := dst.ProtoReflect().Descriptor().FullName(), req.dm.Descriptor().FullName(); got != want {
+		return fmt.Errorf("mismatched message type: got %s, want %s", got, want)
+	}
+	b, err := protov2.Marshal(req.dm)
+	if err != nil {
+		return err
+	}
+	return protov2.Unmarshal(b, dst)
+}
+
+// As returns the request message as T.
+func As[T protov2.Message](req *Request) (T, error) {
+	var zero T
+	if any(zero) == nil {
+		return zero, errors.New("type parameter must be a concrete message type")
+	}
+	dst, ok := zero.ProtoReflect().New().Interface().(T)
+	if !ok {
+		return zero, fmt.Errorf("unexpected message type: %T", zero)
+	}
+	if err := req.Unmarshal(dst); err != nil {
+		return zero, err
+	}
+	return dst, nil
 }
 
 func (req *Request) String() string {
@@ -104,11 +173,84 @@ func newRequest(md protoreflect.MethodDescriptor, message Message) *Request {
 	}
 }
 
+// newIncomingRequest returns the request of the message received with the context.
+func newIncomingRequest(ctx context.Context, md protoreflect.MethodDescriptor, in *dynamicpb.Message) (*Request, error) {
+	m, err := MarshalProtoMessage(in)
+	if err != nil {
+		return nil, err
+	}
+	r := newRequest(md, m)
+	r.dm = in
+	r.setContext(ctx)
+	return r, nil
+}
+
+// setContext sets the incoming context and the information of the call derived from it.
+func (req *Request) setContext(ctx context.Context) {
+	req.ctx = ctx
+	if h, ok := metadata.FromIncomingContext(ctx); ok {
+		req.Headers = h
+	}
+	req.PeerCertificates = peerCertificates(ctx)
+	if p, ok := peer.FromContext(ctx); ok {
+		req.Peer = p
+	}
+	if d, ok := ctx.Deadline(); ok {
+		req.Deadline = d
+	}
+	req.Compression = recvCompression(ctx, req.Headers)
+}
+
+// Context returns the incoming context of the request.
+// The context is canceled when the client cancels the call or the deadline is exceeded.
+// It returns context.Background() when the request is not received by the server.
+func (req *Request) Context() context.Context {
+	if req.ctx == nil {
+		return context.Background()
+	}
+	return req.ctx
+}
+
+// recvCompression returns the name of the compressor of the request message.
+func recvCompression(ctx context.Context, h metadata.MD) string {
+	if ts, ok := grpc.ServerTransportStreamFromContext(ctx).(interface{ RecvCompress() string }); ok {
+		if c := ts.RecvCompress(); c != "" && c != "identity" {
+			return c
+		}
+		return ""
+	}
+	// Connect, gRPC-Web and HTTP/JSON gateway
+	for _, k := range []string{"grpc-encoding", "connect-content-encoding", "content-encoding"} {
+		if v := h.Get(k); len(v) > 0 && v[0] != "identity" {
+			return v[0]
+		}
+	}
+	return ""
+}
+
+// peerCertificates returns the client certificates verified by TLS handshake.
+func peerCertificates(ctx context.Context) []*x509.Certificate {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok {
+		return nil
+	}
+	return info.State.PeerCertificates
+}
+
 type Response struct {
 	Headers  metadata.MD
 	Messages []Message
 	Trailers metadata.MD
 	Status   *status.Status
+	// StatusAfterMessages returns Status after sending Messages in server streaming and bidirectional streaming.
+	StatusAfterMessages bool
+	script              *streamScript
+	// resolver resolves the types of google.protobuf.Any in Messages which are not registered as Go types.
+	resolver *resolver
 }
 
 // NewResponse returns a new empty response
@@ -128,6 +270,8 @@ type Server struct {
 	server            *grpc.Server
 	tlsc              *tls.Config
 	cacert            []byte
+	clientCert        *tls.Certificate
+	autoTLS           bool
 	cc                *grpc.ClientConn
 	requests          []*Request
 	unmatchedRequests []*Request
@@ -135,16 +279,50 @@ type Server struct {
 	disableReflection bool
 	status            serverStatus
 	prependOnce       bool
+	delay             delay
+	proxy             *grpc.ClientConn
+	recordPath        string
+	records           []*record
+	recordMu          sync.Mutex
+	network           string
+	addr              string
+	onRequest         func(req *Request)
+	admin             bool
+	connect           bool
+	httpGateway       bool
+	httpServer        *http.Server
+	enableOperations  bool
+	failOnUnmatched   bool
+	operations        []*operation
+	operationMu       sync.Mutex
+	replayPaths       []string
+	stubPaths         []string
+	matcherSeq        int
+	scenarios         map[string]string
+	scenarioMu        sync.Mutex
 	t                 TB
 	mu                sync.RWMutex
 }
 
 type matcher struct {
-	matchFuncs []matchFunc
-	handler    handlerFunc
-	requests   []*Request
-	t          TB
-	mu         sync.RWMutex
+	matchFuncs   []matchFunc
+	handler      handlerFunc
+	handlers     []handlerFunc
+	sequenceMode SequenceMode
+	calls        int
+	limit        int
+	limited      bool
+	expectation  *expectation
+	delay        delay
+	id           string
+	stub         *stub
+	scenario     string
+	inState      string
+	newState     string
+	requests     []*Request
+	s            *Server
+	t            TB
+	mu           sync.RWMutex
 }
 
 type matchFunc func(req *Request) bool
@@ -180,10 +358,61 @@ func NewServer(t TB, protopath string, opts ...Option) *Server {
 		t:                 t,
 		healthCheck:       c.healthCheck,
 		disableReflection: c.disableReflection,
+		delay:             c.delay,
+		recordPath:        c.recordPath,
+		network:           c.network,
+		addr:              c.addr,
+		onRequest:         c.onRequest,
+		admin:             c.admin,
+		connect:           c.connect,
+		httpGateway:       c.httpGateway,
+		enableOperations:  c.operations,
+		failOnUnmatched:   c.failOnUnmatched,
+		replayPaths:       c.replayPaths,
+		stubPaths:         c.stubPaths,
 	}
 	if err := s.resolveProtos(ctx, c); err != nil {
 		t.Fatal(err)
 	}
+	if c.proxyTarget != "" {
+		cc, err := dialProxy(c.proxyTarget, c.proxyDialOpts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.proxy = cc
+	}
+	for _, p := range c.replayPaths {
+		if err := s.replay(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, p := range c.stubPaths {
+		if err := s.loadStubs(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c.autoTLS && (c.cacert != nil || c.cert != nil || c.key != nil) {
+		t.Fatal(errors.New("AutoTLS cannot be used with the certificates of UseTLS"))
+	}
+	if c.autoTLS {
+		gc, err := generateCertificates()
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.cacert, c.cert, c.key = gc.cacert, gc.cert, gc.key
+		if c.clientAuth != tls.NoClientCert && c.clientCA == nil {
+			c.clientCA = gc.cacert
+		}
+		if c.clientCert == nil {
+			c.clientCert, c.clientKey = gc.clientCert, gc.clientKey
+		}
+	}
+	if !c.useTLS && (c.clientAuth != tls.NoClientCert || c.clientCert != nil) {
+		t.Fatal(errors.New("MTLS, ClientAuth and ClientCertificate require TLS: use UseTLS or AutoTLS"))
+	}
+	if requiresClientCert(c.clientAuth) && c.clientCert == nil {
+		t.Fatal(errors.New("ClientCertificate is required for Conn() when the server requires client certificates"))
+	}
 	if c.useTLS {
 		certificate, err := tls.X509KeyPair(c.cert, c.key)
 		if err != nil {
@@ -191,18 +420,46 @@ func NewServer(t TB, protopath string, opts ...Option) *Server {
 		}
 		tlsc := &tls.Config{
 			Certificates: []tls.Certificate{certificate},
+			ClientAuth:   c.clientAuth,
+		}
+		if c.clientCA != nil {
+			pool := x509.NewCertPool()
+			if ok := pool.AppendCertsFromPEM(c.clientCA); !ok {
+				t.Fatal(errors.New("failed to append client ca certs"))
+			}
+			tlsc.ClientCAs = pool
+		}
+		if c.clientCert != nil {
+			clientCert, err := tls.X509KeyPair(c.clientCert, c.clientKey)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.clientCert = &clientCert
 		}
 		creds := credentials.NewTLS(tlsc)
 		s.tlsc = tlsc
 		s.cacert = c.cacert
+		s.autoTLS = c.autoTLS
 		s.server = grpc.NewServer(grpc.Creds(creds))
 	} else {
 		s.server = grpc.NewServer()
 	}
 	s.startServer()
+	if tc, ok := t.(interface{ Cleanup(func()) }); ok {
+		tc.Cleanup(func() {
+			if s.assertAtCleanup() {
+				s.AssertExpectations()
+			}
+		})
+	}
 	return s
 }
 
+// requiresClientCert reports whether the client must present the certificate.
+func requiresClientCert(mode tls.ClientAuthType) bool {
+	return mode == tls.RequireAnyClientCert || mode == tls.RequireAndVerifyClientCert
+}
+
 // NewTLSServer returns a new server with registered secure *grpc.Server
 func NewTLSServer(t TB, protopath string, cacert, cert, key []byte, opts ...Option) *Server {
 	t.Helper()
@@ -229,6 +486,10 @@ func (s *Server) Close() {
 		_ = s.cc.Close()
 		s.cc = nil
 	}
+	if s.proxy != nil {
+		_ = s.proxy.Close()
+		s.proxy = nil
+	}
 	done := make(chan struct{})
 	go func() {
 		s.server.GracefulStop()
@@ -243,6 +504,14 @@ func (s *Server) Close() {
 	case <-t.C:
 		s.server.Stop()
 	}
+	if s.httpServer != nil {
+		_ = s.httpServer.Close()
+	}
+}
+
+// CACert returns the CA certificate (PEM) which the server certificate is signed by.
+func (s *Server) CACert() []byte {
+	return s.cacert
 }
 
 // Addr returns server listener address
@@ -266,20 +535,38 @@ func (s *Server) Conn() *grpc.ClientConn {
 	if s.tlsc == nil {
 		creds = insecure.NewCredentials()
 	} else {
+		tlsc := &tls.Config{}
+		if s.clientCert != nil {
+			tlsc.Certificates = []tls.Certificate{*s.clientCert}
+		}
 		if s.cacert == nil {
-			s.tlsc.InsecureSkipVerify = true
+			tlsc.InsecureSkipVerify = true
 		} else {
 			pool := x509.NewCertPool()
 			if ok := pool.AppendCertsFromPEM(s.cacert); !ok {
 				s.t.Fatal(errors.New("failed to append ca certs"))
 			}
-			s.tlsc.RootCAs = pool
+			tlsc.RootCAs = pool
+		}
+		creds = credentials.NewTLS(tlsc)
+	}
+	target := s.listener.Addr().String()
+	opts := []grpc.DialOption{grpc.WithTransportCredentials(creds)}
+	switch l := s.listener.(type) {
+	case *bufconn.Listener:
+		opts = append(opts, grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return l.DialContext(ctx)
+		}))
+		if s.autoTLS {
+			// The generated certificate is issued for localhost.
+			opts = append(opts, grpc.WithAuthority("localhost"))
 		}
-		creds = credentials.NewTLS(s.tlsc)
+	case *net.UnixListener:
+		target = "unix:" + target
 	}
 	conn, err := grpc.Dial( //nolint:staticcheck
-		s.listener.Addr().String(),
-		grpc.WithTransportCredentials(creds),
+		target,
+		opts...,
 	)
 	if err != nil {
 		s.t.Error(err)
@@ -307,22 +594,69 @@ func (s *Server) startServer() {
 	if !s.disableReflection {
 		reflection.Register(s.server)
 	}
+	if s.admin {
+		if err := s.registerAdminServer(); err != nil {
+			s.t.Error(err)
+			return
+		}
+	}
 	s.registerServer()
-	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if s.enableOperations {
+		if err := s.registerOperationsServer(); err != nil {
+			s.t.Error(err)
+			return
+		}
+	}
+	l, err := s.listen()
 	if err != nil {
 		s.t.Error(err)
 		return
 	}
 	s.listener = l
+	if s.connect || s.httpGateway {
+		h, err := s.httpHandler()
+		if err != nil {
+			s.t.Error(err)
+			return
+		}
+		s.httpServer = &http.Server{Handler: h, ReadHeaderTimeout: 10 * time.Second}
+		if s.tlsc != nil {
+			s.httpServer.TLSConfig = s.tlsc.Clone()
+			go func() {
+				_ = s.httpServer.ServeTLS(l, "", "")
+			}()
+			return
+		}
+		go func() {
+			_ = s.httpServer.Serve(l)
+		}()
+		return
+	}
 	go func() {
 		_ = s.server.Serve(l)
 	}()
 }
 
+func (s *Server) listen() (net.Listener, error) {
+	switch s.network {
+	case networkInMemory:
+		return bufconn.Listen(bufconnSize), nil
+	case "unix":
+		return net.Listen("unix", s.addr)
+	default:
+		addr := s.addr
+		if addr == "" {
+			addr = "127.0.0.1:0"
+		}
+		return net.Listen("tcp", addr)
+	}
+}
+
 // Match create request matcher with matchFunc (func(req *grpcstub.Request) bool).
 func (s *Server) Match(fn func(req *Request) bool) *matcher {
 	m := &matcher{
 		matchFuncs: []matchFunc{fn},
+		s:          s,
 		t:          s.t,
 	}
 	s.mu.Lock()
@@ -346,6 +680,7 @@ func (s *Server) Service(service string) *matcher {
 	fn := serviceMatchFunc(service)
 	m := &matcher{
 		matchFuncs: []matchFunc{fn},
+		s:          s,
 		t:          s.t,
 	}
 	s.addMatcher(m)
@@ -378,6 +713,7 @@ func (s *Server) Method(method string) *matcher {
 	fn := methodMatchFunc(method)
 	m := &matcher{
 		matchFuncs: []matchFunc{fn},
+		s:          s,
 		t:          s.t,
 	}
 	s.addMatcher(m)
@@ -444,18 +780,9 @@ func (m *matcher) Handler(fn func(req *Request) *Response) {
 
 // Response set handler which return response.
 func (m *matcher) Response(message any) *matcher {
-	mm := map[string]any{}
-	switch v := message.(type) {
-	case map[string]any:
-		mm = v
-	default:
-		b, err := json.Marshal(v)
-		if err != nil {
-			m.t.Fatalf("failed to convert message: %v", err)
-		}
-		if err := json.Unmarshal(b, &mm); err != nil {
-			m.t.Fatalf("failed to convert message: %v", err)
-		}
+	mm, err := convertMessage(message)
+	if err != nil {
+		m.t.Fatalf("failed to convert message: %v", err)
 	}
 	prev := m.handler
 	m.handler = func(req *Request, md protoreflect.MethodDescriptor) *Response {
@@ -471,6 +798,21 @@ func (m *matcher) Response(message any) *matcher {
 	return m
 }
 
+func convertMessage(message any) (map[string]any, error) {
+	if v, ok := message.(map[string]any); ok {
+		return v, nil
+	}
+	mm := map[string]any{}
+	b, err := json.Marshal(message)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &mm); err != nil {
+		return nil, err
+	}
+	return mm, nil
+}
+
 // ResponseString set handler which return response.
 func (m *matcher) ResponseString(message string) *matcher {
 	mes := make(map[string]any)
@@ -499,6 +841,51 @@ func (m *matcher) Status(s *status.Status) *matcher {
 	return m
 }
 
+// StatusAfterMessages set handler which return response with status after sending messages.
+// It is for server streaming and bidirectional streaming, e.g. Response(m1).Response(m2).StatusAfterMessages(status.New(codes.Unavailable, "unavailable")).
+func (m *matcher) StatusAfterMessages(s *status.Status) *matcher {
+	prev := m.handler
+	m.handler = func(req *Request, md protoreflect.MethodDescriptor) *Response {
+		var res *Response
+		if prev == nil {
+			res = NewResponse()
+		} else {
+			res = prev(req, md)
+		}
+		res.Status = s
+		res.StatusAfterMessages = true
+		return res
+	}
+	return m
+}
+
+// Times limit the number of times the matcher matches.
+// After being consumed, requests fall through to the next matcher.
+func (m *matcher) Times(n int) *matcher {
+	if n < 0 {
+		m.t.Fatalf("invalid times: %d", n)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.limit = n
+	m.limited = true
+	return m
+}
+
+// Once limit the matcher to match only once.
+func (m *matcher) Once() *matcher {
+	return m.Times(1)
+}
+
+// AtMost limit the matcher to match at most n times. AtMost(0) never matches.
+// After being consumed, requests fall through to the next matcher.
+func (m *matcher) AtMost(n int) *matcher {
+	if n < 0 {
+		m.t.Fatalf("invalid at most: %d", n)
+	}
+	return m.Times(n)
+}
+
 // Requests returns []*grpcstub.Request received by router.
 func (s *Server) Requests() []*Request {
 	s.mu.RLock()
@@ -515,6 +902,8 @@ func (s *Server) UnmatchedRequests() []*Request {
 
 // ClearMatchers clear matchers.
 func (s *Server) ClearMatchers() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.matchers = nil
 }
 
@@ -528,6 +917,8 @@ func (s *Server) Prepend() *Server {
 
 // ClearRequests clear requests.
 func (s *Server) ClearRequests() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.requests = nil
 	s.unmatchedRequests = nil
 }
@@ -540,6 +931,8 @@ func (m *matcher) Requests() []*Request {
 }
 
 func (s *Server) addMatcher(m *matcher) {
+	s.matcherSeq++
+	m.id = strconv.Itoa(s.matcherSeq)
 	if s.prependOnce {
 		s.matchers = append([]*matcher{m}, s.matchers...)
 		s.prependOnce = false
@@ -551,7 +944,12 @@ func (s *Server) addMatcher(m *matcher) {
 func (s *Server) registerServer() {
 	for _, fd := range s.fds {
 		for i := 0; i < fd.Services().Len(); i++ {
-			s.server.RegisterService(s.createServiceDesc(fd.Services().Get(i)), nil)
+			sd := fd.Services().Get(i)
+			if s.enableOperations && sd.FullName() == operationsServiceName {
+				// registered by registerOperationsServer
+				continue
+			}
+			s.server.RegisterService(s.createServiceDesc(sd), nil)
 		}
 	}
 	if !s.healthCheck {
@@ -626,58 +1024,39 @@ func (s *Server) createUnaryHandler(md protoreflect.MethodDescriptor) func(srv a
 		if err := dec(in); err != nil {
 			return nil, err
 		}
-		m, err := MarshalProtoMessage(in)
+		req, err := newIncomingRequest(ctx, md, in)
 		if err != nil {
 			return nil, err
 		}
-		req := newRequest(md, m)
-		h, ok := metadata.FromIncomingContext(ctx)
-		if ok {
-			req.Headers = h
-		}
 
-		var mes *dynamicpb.Message
-		for _, m := range s.matchers {
-			if !m.matchRequest(req) {
-				continue
-			}
-			s.mu.Lock()
-			s.requests = append(s.requests, req)
-			s.mu.Unlock()
-			m.mu.Lock()
-			m.requests = append(m.requests, req)
-			m.mu.Unlock()
-			res := m.handler(req, md)
-			for k, v := range res.Headers {
-				for _, vv := range v {
-					if err := grpc.SetHeader(ctx, metadata.Pairs(k, vv)); err != nil {
-						return nil, err
-					}
-				}
-			}
-			for k, v := range res.Trailers {
-				for _, vv := range v {
-					if err := grpc.SetTrailer(ctx, metadata.Pairs(k, vv)); err != nil {
-						return nil, err
-					}
+		res, err := s.handle(ctx, md, req)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range res.Headers {
+			for _, vv := range v {
+				if err := grpc.SetHeader(ctx, metadata.Pairs(k, vv)); err != nil {
+					return nil, err
 				}
 			}
-			if res.Status != nil && res.Status.Err() != nil {
-				return nil, res.Status.Err()
-			}
-			mes = dynamicpb.NewMessage(md.Output())
-			if len(res.Messages) > 0 {
-				if err := UnmarshalProtoMessage(res.Messages[0], mes); err != nil {
+		}
+		for k, v := range res.Trailers {
+			for _, vv := range v {
+				if err := grpc.SetTrailer(ctx, metadata.Pairs(k, vv)); err != nil {
 					return nil, err
 				}
 			}
-			return mes, nil
 		}
-
-		s.mu.Lock()
-		s.unmatchedRequests = append(s.unmatchedRequests, req)
-		s.mu.Unlock()
-		return mes, status.Error(codes.NotFound, codes.NotFound.String())
+		if res.Status != nil && res.Status.Err() != nil {
+			return nil, res.Status.Err()
+		}
+		mes := dynamicpb.NewMessage(md.Output())
+		if len(res.Messages) > 0 {
+			if err := unmarshalProtoMessage(res.Messages[0], mes, res.resolver); err != nil {
+				return nil, err
+			}
+		}
+		return mes, nil
 	}
 }
 
@@ -702,58 +1081,28 @@ func (s *Server) createServerStreamingHandler(md protoreflect.MethodDescriptor)
 		if err := stream.RecvMsg(in); err != nil {
 			return err
 		}
-		m, err := MarshalProtoMessage(in)
+		r, err := newIncomingRequest(stream.Context(), md, in)
 		if err != nil {
 			return err
 		}
-		r := newRequest(md, m)
-		h, ok := metadata.FromIncomingContext(stream.Context())
-		if ok {
-			r.Headers = h
+		r.serverStream = stream
+		res, err := s.handle(stream.Context(), md, r)
+		if err != nil {
+			return err
 		}
-		for _, m := range s.matchers {
-			if !m.matchRequest(r) {
-				continue
-			}
-			m.mu.Lock()
-			m.requests = append(m.requests, r)
-			m.mu.Unlock()
-			s.mu.Lock()
-			s.requests = append(s.requests, r)
-			s.mu.Unlock()
-			res := m.handler(r, md)
-			for k, v := range res.Headers {
-				for _, vv := range v {
-					if err := stream.SendHeader(metadata.Pairs(k, vv)); err != nil {
-						return err
-					}
-				}
-			}
-			for k, v := range res.Trailers {
-				for _, vv := range v {
-					stream.SetTrailer(metadata.Pairs(k, vv))
+		for k, v := range res.Headers {
+			for _, vv := range v {
+				if err := stream.SendHeader(metadata.Pairs(k, vv)); err != nil {
+					return err
 				}
 			}
-			if res.Status != nil && res.Status.Err() != nil {
-				return res.Status.Err()
-			}
-			if len(res.Messages) > 0 {
-				for _, resm := range res.Messages {
-					mes := dynamicpb.NewMessage(md.Output())
-					if err := UnmarshalProtoMessage(resm, mes); err != nil {
-						return err
-					}
-					if err := stream.SendMsg(mes); err != nil {
-						return err
-					}
-				}
+		}
+		for k, v := range res.Trailers {
+			for _, vv := range v {
+				stream.SetTrailer(metadata.Pairs(k, vv))
 			}
-			return nil
 		}
-		s.mu.Lock()
-		s.unmatchedRequests = append(s.unmatchedRequests, r)
-		s.mu.Unlock()
-		return status.Error(codes.NotFound, codes.NotFound.String())
+		return sendMessages(stream, md, res)
 	}
 }
 
@@ -764,15 +1113,10 @@ func (s *Server) createClientStreamingHandler(md protoreflect.MethodDescriptor)
 			in := dynamicpb.NewMessage(md.Input())
 			err := stream.RecvMsg(in)
 			if err == nil {
-				m, err := MarshalProtoMessage(in)
+				r, err := newIncomingRequest(stream.Context(), md, in)
 				if err != nil {
 					return err
 				}
-				r := newRequest(md, m)
-				h, ok := metadata.FromIncomingContext(stream.Context())
-				if ok {
-					r.Headers = h
-				}
 				rs = append(rs, r)
 				continue
 			}
@@ -784,46 +1128,35 @@ func (s *Server) createClientStreamingHandler(md protoreflect.MethodDescriptor)
 				return err
 			}
 
-			var mes *dynamicpb.Message
-			for _, m := range s.matchers {
-				if !m.matchRequest(rs...) {
-					continue
-				}
-				s.mu.Lock()
-				s.requests = append(s.requests, rs...)
-				s.mu.Unlock()
-				m.mu.Lock()
-				m.requests = append(m.requests, rs...)
-				m.mu.Unlock()
-				last := rs[len(rs)-1]
-				res := m.handler(last, md)
-				if res.Status != nil && res.Status.Err() != nil {
-					return res.Status.Err()
+			if len(rs) > 0 {
+				rs[len(rs)-1].stream = rs
+			}
+			res, err := s.handle(stream.Context(), md, rs...)
+			if err != nil {
+				return err
+			}
+			if res.Status != nil && res.Status.Err() != nil {
+				return res.Status.Err()
+			}
+			mes := dynamicpb.NewMessage(md.Output())
+			if len(res.Messages) > 0 {
+				if err := unmarshalProtoMessage(res.Messages[0], mes, res.resolver); err != nil {
+					return err
 				}
-				mes = dynamicpb.NewMessage(md.Output())
-				if len(res.Messages) > 0 {
-					if err := UnmarshalProtoMessage(res.Messages[0], mes); err != nil {
+			}
+			for k, v := range res.Headers {
+				for _, vv := range v {
+					if err := stream.SendHeader(metadata.Pairs(k, vv)); err != nil {
 						return err
 					}
 				}
-				for k, v := range res.Headers {
-					for _, vv := range v {
-						if err := stream.SendHeader(metadata.Pairs(k, vv)); err != nil {
-							return err
-						}
-					}
-				}
-				for k, v := range res.Trailers {
-					for _, vv := range v {
-						stream.SetTrailer((metadata.Pairs(k, vv)))
-					}
+			}
+			for k, v := range res.Trailers {
+				for _, vv := range v {
+					stream.SetTrailer((metadata.Pairs(k, vv)))
 				}
-				return stream.SendMsg(mes)
 			}
-			s.mu.Lock()
-			s.unmatchedRequests = append(s.unmatchedRequests, rs...)
-			s.mu.Unlock()
-			return status.Error(codes.NotFound, codes.NotFound.String())
+			return stream.SendMsg(mes)
 		}
 	}
 }
@@ -831,7 +1164,6 @@ func (s *Server) createClientStreamingHandler(md protoreflect.MethodDescriptor)
 func (s *Server) createBidiStreamingHandler(md protoreflect.MethodDescriptor) func(srv any, stream grpc.ServerStream) error {
 	return func(srv any, stream grpc.ServerStream) error {
 		headerSent := false
-	L:
 		for {
 			in := dynamicpb.NewMessage(md.Input())
 			err := stream.RecvMsg(in)
@@ -841,63 +1173,63 @@ func (s *Server) createBidiStreamingHandler(md protoreflect.MethodDescriptor) fu
 			if err != nil {
 				return err
 			}
-			m, err := MarshalProtoMessage(in)
+			r, err := newIncomingRequest(stream.Context(), md, in)
 			if err != nil {
 				return err
 			}
-			r := newRequest(md, m)
-			h, ok := metadata.FromIncomingContext(stream.Context())
-			if ok {
-				r.Headers = h
+			r.serverStream = stream
+			res, err := s.handle(stream.Context(), md, r)
+			if err != nil {
+				return err
 			}
-			for _, m := range s.matchers {
-				if !m.matchRequest(r) {
-					continue
-				}
-				s.mu.Lock()
-				s.requests = append(s.requests, r)
-				s.mu.Unlock()
-				m.mu.Lock()
-				m.requests = append(m.requests, r)
-				m.mu.Unlock()
-				res := m.handler(r, md)
-				if !headerSent {
-					for k, v := range res.Headers {
-						for _, vv := range v {
-							if err := stream.SendHeader(metadata.Pairs(k, vv)); err != nil {
-								return err
-							}
-							headerSent = true
-						}
-					}
-				}
-				for k, v := range res.Trailers {
+			if !headerSent {
+				for k, v := range res.Headers {
 					for _, vv := range v {
-						stream.SetTrailer(metadata.Pairs(k, vv))
-					}
-				}
-				if res.Status != nil && res.Status.Err() != nil {
-					return res.Status.Err()
-				}
-				if len(res.Messages) > 0 {
-					for _, resm := range res.Messages {
-						mes := dynamicpb.NewMessage(md.Output())
-						if err := UnmarshalProtoMessage(resm, mes); err != nil {
-							return err
-						}
-						if err := stream.SendMsg(mes); err != nil {
+						if err := stream.SendHeader(metadata.Pairs(k, vv)); err != nil {
 							return err
 						}
+						headerSent = true
 					}
 				}
-				continue L
 			}
-			s.mu.Lock()
-			s.unmatchedRequests = append(s.unmatchedRequests, r)
-			s.mu.Unlock()
-			return status.Error(codes.NotFound, codes.NotFound.String())
+			for k, v := range res.Trailers {
+				for _, vv := range v {
+					stream.SetTrailer(metadata.Pairs(k, vv))
+				}
+			}
+			if err := sendMessages(stream, md, res); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// sendMessages sends the messages of the response to the stream.
+// When the response has a stream script, the steps are played in order.
+func sendMessages(stream grpc.ServerStream, md protoreflect.MethodDescriptor, res *Response) error {
+	if res.script != nil {
+		return res.script.play(stream, md)
+	}
+	if !res.StatusAfterMessages && res.Status != nil && res.Status.Err() != nil {
+		return res.Status.Err()
+	}
+	for _, resm := range res.Messages {
+		if err := sendMessage(stream, md, resm); err != nil {
+			return err
 		}
 	}
+	if res.Status != nil {
+		return res.Status.Err()
+	}
+	return nil
+}
+
+func sendMessage(stream grpc.ServerStream, md protoreflect.MethodDescriptor, m Message) error {
+	mes := dynamicpb.NewMessage(md.Output())
+	if err := UnmarshalProtoMessage(m, mes); err != nil {
+		return err
+	}
+	return stream.SendMsg(mes)
 }
 
 // MarshalProtoMessage marshals [proto.Message] to [Message].
@@ -925,6 +1257,115 @@ func UnmarshalProtoMessage(m Message, pm protoreflect.ProtoMessage) error {
 	return nil
 }
 
+// unmarshalProtoMessage unmarshals [Message] to [proto.Message] using the resolver.
+// It is the same as UnmarshalProtoMessage when the resolver is nil.
+func unmarshalProtoMessage(m Message, pm protoreflect.ProtoMessage, r *resolver) error {
+	if r == nil {
+		return UnmarshalProtoMessage(m, pm)
+	}
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return (protojson.UnmarshalOptions{Resolver: r}).Unmarshal(b, pm)
+}
+
+// handle finds the matcher for the requests, records the requests and returns the response after the delay.
+// It returns NotFound error when no matcher matches.
+func (s *Server) handle(ctx context.Context, md protoreflect.MethodDescriptor, rs ...*Request) (*Response, error) {
+	if s.onRequest != nil {
+		for _, r := range rs {
+			s.onRequest(r)
+		}
+	}
+	s.mu.RLock()
+	matchers := s.matchers
+	s.mu.RUnlock()
+	for _, m := range matchers {
+		n, ok := m.consume(rs...)
+		if !ok {
+			continue
+		}
+		s.mu.Lock()
+		s.requests = append(s.requests, rs...)
+		s.mu.Unlock()
+		m.mu.Lock()
+		m.requests = append(m.requests, rs...)
+		m.mu.Unlock()
+		if err := sleep(ctx, s.delay.duration()+m.delay.duration()); err != nil {
+			return nil, err
+		}
+		var last *Request
+		if len(rs) > 0 {
+			last = rs[len(rs)-1]
+		} else {
+			last = newRequest(md, nil)
+			last.setContext(ctx)
+		}
+		return m.respond(n, last, md), nil
+	}
+	if s.proxy != nil {
+		s.mu.Lock()
+		s.requests = append(s.requests, rs...)
+		s.mu.Unlock()
+		if err := sleep(ctx, s.delay.duration()); err != nil {
+			return nil, err
+		}
+		return s.forward(ctx, md, rs)
+	}
+	s.mu.Lock()
+	s.unmatchedRequests = append(s.unmatchedRequests, rs...)
+	s.mu.Unlock()
+	if err := sleep(ctx, s.delay.duration()); err != nil {
+		return nil, err
+	}
+	return nil, status.Error(codes.NotFound, codes.NotFound.String())
+}
+
+// consume reports whether the requests match and returns the index of the call.
+func (m *matcher) consume(rs ...*Request) (int, bool) {
+	// The match functions run without the lock because they may read the matcher (e.g. m.Requests()).
+	if !m.matchRequest(rs...) {
+		return 0, false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.sequenceMode == SequenceFallThrough && m.calls > len(m.handlers) {
+		return 0, false
+	}
+	if m.limited && m.calls >= m.limit {
+		return 0, false
+	}
+	if !m.s.transitScenario(m.scenario, m.inState, m.newState) {
+		return 0, false
+	}
+	n := m.calls
+	m.calls++
+	return n, true
+}
+
+// respond returns the response for the n-th call.
+func (m *matcher) respond(n int, req *Request, md protoreflect.MethodDescriptor) *Response {
+	m.mu.RLock()
+	handlers := append(slices.Clone(m.handlers), m.handler)
+	mode := m.sequenceMode
+	m.mu.RUnlock()
+	i := n
+	if i >= len(handlers) {
+		switch mode {
+		case SequenceCycle:
+			i = n % len(handlers)
+		default:
+			i = len(handlers) - 1
+		}
+	}
+	h := handlers[i]
+	if h == nil {
+		return NewResponse()
+	}
+	return h(req, md)
+}
+
 func (m *matcher) matchRequest(rs ...*Request) bool {
 	for _, r := range rs {
 		for _, fn := range m.matchFuncs {
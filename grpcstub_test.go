@@ -3,9 +3,12 @@ package grpcstub
 import (
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -21,9 +24,12 @@ import (
 	"golang.org/x/net/http2"
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/encoding/gzip"
 	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/metadata"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/testing/protocmp"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
@@ -545,6 +551,232 @@ func TestTLSServer(t *testing.T) {
 	}
 }
 
+func TestMTLS(t *testing.T) {
+	ctx := context.Background()
+	cacert, err := os.ReadFile("testdata/cacert.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := os.ReadFile("testdata/cert.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := os.ReadFile("testdata/key.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCert, err := os.ReadFile("testdata/clientcert.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientKey, err := os.ReadFile("testdata/clientkey.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	if ok := pool.AppendCertsFromPEM(cacert); !ok {
+		t.Fatal("failed to append ca certs")
+	}
+
+	t.Run("match client cert", func(t *testing.T) {
+		tests := []struct {
+			name string
+			want string
+		}{
+			{"alice", "alice"},
+			{"alice@example.com", "alice"},
+			{"spiffe://example.com/alice", "alice"},
+			{"bob", "default"},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				ts := NewTLSServer(t, "testdata/route_guide.proto", cacert, cert, key, MTLS(cacert), ClientCertificate(clientCert, clientKey))
+				t.Cleanup(func() {
+					ts.Close()
+				})
+				ts.Method("GetFeature").MatchClientCert(tt.name).Response(map[string]any{"name": "alice"})
+				ts.Method("GetFeature").Response(map[string]any{"name": "default"})
+				client := routeguide.NewRouteGuideClient(ts.Conn())
+				res, err := client.GetFeature(ctx, &routeguide.Point{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got := res.Name; got != tt.want {
+					t.Errorf("got %v\nwant %v", got, tt.want)
+				}
+				if len(ts.Requests()) != 1 || len(ts.Requests()[0].PeerCertificates) == 0 {
+					t.Fatal("want peer certificates")
+				}
+				if got, want := ts.Requests()[0].PeerCertificates[0].Subject.CommonName, "alice"; got != want {
+					t.Errorf("got %v\nwant %v", got, want)
+				}
+			})
+		}
+	})
+
+	t.Run("require client cert", func(t *testing.T) {
+		ts := NewTLSServer(t, "testdata/route_guide.proto", cacert, cert, key, MTLS(cacert), ClientCertificate(clientCert, clientKey))
+		t.Cleanup(func() {
+			ts.Close()
+		})
+		ts.Method("GetFeature").Response(map[string]any{"name": "hello"})
+		conn, err := grpc.NewClient(ts.Addr(), grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{RootCAs: pool})))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			_ = conn.Close()
+		})
+		client := routeguide.NewRouteGuideClient(conn)
+		if _, err := client.GetFeature(ctx, &routeguide.Point{}); status.Code(err) != codes.Unavailable {
+			t.Errorf("got %v\nwant %v", status.Code(err), codes.Unavailable)
+		}
+	})
+
+	t.Run("verify client cert if given", func(t *testing.T) {
+		ts := NewTLSServer(t, "testdata/route_guide.proto", cacert, cert, key, MTLS(cacert), ClientAuth(tls.VerifyClientCertIfGiven))
+		t.Cleanup(func() {
+			ts.Close()
+		})
+		ts.Method("GetFeature").MatchClientCert("alice").Response(map[string]any{"name": "alice"})
+		ts.Method("GetFeature").Response(map[string]any{"name": "default"})
+		conn, err := grpc.NewClient(ts.Addr(), grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{RootCAs: pool})))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			_ = conn.Close()
+		})
+		client := routeguide.NewRouteGuideClient(conn)
+		res, err := client.GetFeature(ctx, &routeguide.Point{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := res.Name, "default"; got != want {
+			t.Errorf("got %v\nwant %v", got, want)
+		}
+	})
+
+	t.Run("invalid configuration", func(t *testing.T) {
+		tests := []struct {
+			name string
+			opts []Option
+		}{
+			{"MTLS without TLS", []Option{MTLS(cacert), ClientCertificate(clientCert, clientKey)}},
+			{"ClientAuth without TLS", []Option{ClientAuth(tls.VerifyClientCertIfGiven)}},
+			{"MTLS without ClientCertificate", []Option{UseTLS(cacert, cert, key), MTLS(cacert)}},
+			{"AutoTLS with UseTLS", []Option{UseTLS(cacert, cert, key), AutoTLS()}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				tb := &fatalTB{TB: t}
+				func() {
+					defer func() {
+						// NewServer continues after the fatal error of fatalTB.
+						_ = recover()
+					}()
+					ts := NewServer(tb, "testdata/route_guide.proto", tt.opts...)
+					if ts != nil && ts.listener != nil {
+						ts.Close()
+					}
+				}()
+				if len(tb.fatals) == 0 {
+					t.Error("want fatal")
+				}
+			})
+		}
+	})
+
+	t.Run("connect", func(t *testing.T) {
+		ts := NewTLSServer(t, "testdata/route_guide.proto", cacert, cert, key, MTLS(cacert), ClientCertificate(clientCert, clientKey), EnableConnect())
+		t.Cleanup(func() {
+			ts.Close()
+		})
+		ts.Method("GetFeature").MatchClientCert("alice").Response(map[string]any{"name": "alice"})
+		certificate, err := tls.X509KeyPair(clientCert, clientKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		hc := &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: pool, Certificates: []tls.Certificate{certificate}},
+			},
+		}
+		client := connect.NewClient[routeguide.Point, routeguide.Feature](hc, fmt.Sprintf("https://%s/routeguide.RouteGuide/GetFeature", ts.Addr()))
+		res, err := client.CallUnary(ctx, connect.NewRequest(&routeguide.Point{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := res.Msg.GetName(), "alice"; got != want {
+			t.Errorf("got %v\nwant %v", got, want)
+		}
+	})
+}
+
+func TestAutoTLS(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name string
+		opts func(t *testing.T) []Option
+	}{
+		{"TCP", func(t *testing.T) []Option { return []Option{AutoTLS()} }},
+		{"UnixSocket", func(t *testing.T) []Option {
+			return []Option{AutoTLS(), UnixSocket(filepath.Join(t.TempDir(), "grpcstub.sock"))}
+		}},
+		{"InMemory", func(t *testing.T) []Option { return []Option{AutoTLS(), InMemory()} }},
+		{"MTLS", func(t *testing.T) []Option { return []Option{AutoTLS(), MTLS(nil)} }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := NewServer(t, "testdata/route_guide.proto", tt.opts(t)...)
+			t.Cleanup(func() {
+				ts.Close()
+			})
+			ts.Method("GetFeature").Response(map[string]any{"name": "hello"})
+			if len(ts.CACert()) == 0 {
+				t.Fatal("want CA certificate")
+			}
+			client := routeguide.NewRouteGuideClient(ts.Conn())
+			res, err := client.GetFeature(ctx, &routeguide.Point{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := "hello"; res.Name != want {
+				t.Errorf("got %v\nwant %v", res.Name, want)
+			}
+		})
+	}
+
+	t.Run("Connect", func(t *testing.T) {
+		ts := NewServer(t, "testdata/route_guide.proto", AutoTLS(), EnableConnect())
+		t.Cleanup(func() {
+			ts.Close()
+		})
+		ts.Method("GetFeature").Response(map[string]any{"name": "hello"})
+		pool := x509.NewCertPool()
+		if ok := pool.AppendCertsFromPEM(ts.CACert()); !ok {
+			t.Fatal("failed to append ca certs")
+		}
+		hc := &http.Client{
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: pool},
+			},
+		}
+		_, port, err := net.SplitHostPort(ts.Addr())
+		if err != nil {
+			t.Fatal(err)
+		}
+		client := connect.NewClient[routeguide.Point, routeguide.Feature](hc, fmt.Sprintf("https://localhost:%s/routeguide.RouteGuide/GetFeature", port))
+		res, err := client.CallUnary(ctx, connect.NewRequest(&routeguide.Point{}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := res.Msg.GetName(), "hello"; got != want {
+			t.Errorf("got %v\nwant %v", got, want)
+		}
+	})
+}
+
 func TestHealthCheck(t *testing.T) {
 	tests := []struct {
 		enable  bool
@@ -652,6 +884,152 @@ func TestRequestStringer(t *testing.T) {
 	}
 }
 
+func TestRequestCallInfo(t *testing.T) {
+	ts := NewServer(t, "testdata/route_guide.proto")
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	var handlerErr error
+	ts.Method("GetFeature").Handler(func(req *Request) *Response {
+		d, ok := req.Context().Deadline()
+		if ok != !req.Deadline.IsZero() || !d.Equal(req.Deadline) {
+			handlerErr = fmt.Errorf("got %v\nwant %v", d, req.Deadline)
+		}
+		return NewResponse()
+	})
+	client := routeguide.NewRouteGuideClient(ts.Conn())
+	deadline := time.Now().Add(time.Minute)
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	t.Cleanup(cancel)
+	if _, err := client.GetFeature(ctx, &routeguide.Point{}, grpc.UseCompressor(gzip.Name)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.GetFeature(context.Background(), &routeguide.Point{}); err != nil {
+		t.Fatal(err)
+	}
+	if handlerErr != nil {
+		t.Error(handlerErr)
+	}
+	reqs := ts.Requests()
+	if len(reqs) != 2 {
+		t.Fatalf("got %v\nwant %v", len(reqs), 2)
+	}
+
+	t.Run("Deadline", func(t *testing.T) {
+		got := reqs[0].Deadline
+		if got.IsZero() || got.Sub(deadline).Abs() > time.Second {
+			t.Errorf("got %v\nwant about %v", got, deadline)
+		}
+		if !reqs[1].Deadline.IsZero() {
+			t.Errorf("got %v\nwant zero", reqs[1].Deadline)
+		}
+	})
+
+	t.Run("Compression", func(t *testing.T) {
+		if got, want := reqs[0].Compression, gzip.Name; got != want {
+			t.Errorf("got %v\nwant %v", got, want)
+		}
+		if got, want := reqs[1].Compression, ""; got != want {
+			t.Errorf("got %v\nwant %v", got, want)
+		}
+	})
+
+	t.Run("Peer", func(t *testing.T) {
+		if reqs[0].Peer == nil {
+			t.Fatal("want peer")
+		}
+		if got := reqs[0].Peer.Addr.String(); !strings.HasPrefix(got, "127.0.0.1:") {
+			t.Errorf("got %v\nwant 127.0.0.1:*", got)
+		}
+	})
+
+	t.Run("Connect", func(t *testing.T) {
+		ts := NewServer(t, "testdata/route_guide.proto", EnableConnect())
+		t.Cleanup(func() {
+			ts.Close()
+		})
+		ts.Method("GetFeature").Response(map[string]any{})
+		client := connect.NewClient[routeguide.Point, routeguide.Feature](h2cClient(), fmt.Sprintf("http://%s/routeguide.RouteGuide/GetFeature", ts.Addr()))
+		if _, err := client.CallUnary(ctx, connect.NewRequest(&routeguide.Point{})); err != nil {
+			t.Fatal(err)
+		}
+		req := ts.Requests()[0]
+		if req.Deadline.IsZero() || req.Deadline.Sub(deadline).Abs() > time.Second {
+			t.Errorf("got %v\nwant about %v", req.Deadline, deadline)
+		}
+		if req.Peer == nil {
+			t.Error("want peer")
+		}
+	})
+}
+
+func TestRequestUnmarshal(t *testing.T) {
+	ctx := context.Background()
+	ts := NewServer(t, "testdata/hello.proto")
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	ts.Method("Hello").Response(map[string]any{"message": "hello"})
+	client := hello.NewGrpcTestServiceClient(ts.Conn())
+	now := time.Date(2026, 1, 2, 3, 4, 5, 6, time.UTC)
+	want := &hello.HelloRequest{Name: "alice", Num: 9007199254740993, RequestTime: timestamppb.New(now)}
+	if _, err := client.Hello(ctx, want); err != nil {
+		t.Fatal(err)
+	}
+	if len(ts.Requests()) != 1 {
+		t.Fatalf("got %v\nwant %v", len(ts.Requests()), 1)
+	}
+	req := ts.Requests()[0]
+
+	t.Run("Unmarshal", func(t *testing.T) {
+		got := &hello.HelloRequest{}
+		if err := req.Unmarshal(got); err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(got, want, protocmp.Transform()); diff != "" {
+			t.Error(diff)
+		}
+	})
+
+	t.Run("As", func(t *testing.T) {
+		got, err := As[*hello.HelloRequest](req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(got, want, protocmp.Transform()); diff != "" {
+			t.Error(diff)
+		}
+	})
+
+	t.Run("mismatched type", func(t *testing.T) {
+		if _, err := As[*hello.HelloResponse](req); err == nil {
+			t.Error("want error")
+		}
+	})
+
+	t.Run("DynamicMessage", func(t *testing.T) {
+		dm := req.DynamicMessage()
+		if dm == nil {
+			t.Fatal("want dynamic message")
+		}
+		got := dm.Get(dm.Descriptor().Fields().ByName("num")).Int()
+		if got != want.Num {
+			t.Errorf("got %v\nwant %v", got, want.Num)
+		}
+	})
+
+	t.Run("without dynamic message", func(t *testing.T) {
+		req := &Request{Message: Message{"name": "bob", "num": "10"}}
+		got, err := As[*hello.HelloRequest](req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Name != "bob" || got.Num != 10 {
+			t.Errorf("got %v\nwant %v", got, "name:bob num:10")
+		}
+	})
+}
+
 func TestResponseAny(t *testing.T) {
 	ctx := context.Background()
 	ts := NewServer(t, "testdata/route_guide.proto")
@@ -854,3 +1232,188 @@ func TestPrepend(t *testing.T) {
 		}
 	})
 }
+
+func TestTimes(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func(m *matcher) *matcher
+		want []string
+	}{
+		{"Once", func(m *matcher) *matcher { return m.Once() }, []string{"limited", "default", "default"}},
+		{"Times", func(m *matcher) *matcher { return m.Times(2) }, []string{"limited", "limited", "default"}},
+		{"Times(0)", func(m *matcher) *matcher { return m.Times(0) }, []string{"default", "default", "default"}},
+		{"AtMost", func(m *matcher) *matcher { return m.AtMost(0) }, []string{"default", "default", "default"}},
+		{"AtMost(2)", func(m *matcher) *matcher { return m.AtMost(2) }, []string{"limited", "limited", "default"}},
+	}
+	ctx := context.Background()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := NewServer(t, "testdata/route_guide.proto")
+			t.Cleanup(func() {
+				ts.Close()
+			})
+			m := tt.fn(ts.Method("GetFeature")).Response(map[string]any{"name": "limited"})
+			ts.Method("GetFeature").Response(map[string]any{"name": "default"})
+
+			client := routeguide.NewRouteGuideClient(ts.Conn())
+			var got []string
+			for range tt.want {
+				res, err := client.GetFeature(ctx, &routeguide.Point{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, res.Name)
+			}
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Error(diff)
+			}
+			{
+				got := len(m.Requests())
+				want := 0
+				for _, n := range tt.want {
+					if n == "limited" {
+						want++
+					}
+				}
+				if got != want {
+					t.Errorf("got %v\nwant %v", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestTimesInvalid(t *testing.T) {
+	ts := NewServer(t, "testdata/route_guide.proto")
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	tb := &fatalTB{TB: t}
+	m := ts.Method("GetFeature")
+	m.t = tb
+	m.Times(-1)
+	if len(tb.fatals) == 0 {
+		t.Error("want fatal")
+	}
+	tb.fatals = nil
+	m.AtMost(-1)
+	if len(tb.fatals) == 0 {
+		t.Error("want fatal")
+	}
+}
+
+func TestOnceThenSucceed(t *testing.T) {
+	ctx := context.Background()
+	ts := NewServer(t, "testdata/route_guide.proto")
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	ts.Method("GetFeature").Once().Status(status.New(codes.Unavailable, "unavailable"))
+	ts.Method("GetFeature").Response(map[string]any{"name": "hello"})
+
+	client := routeguide.NewRouteGuideClient(ts.Conn())
+	if _, err := client.GetFeature(ctx, &routeguide.Point{}); status.Code(err) != codes.Unavailable {
+		t.Errorf("got %v\nwant %v", status.Code(err), codes.Unavailable)
+	}
+	res, err := client.GetFeature(ctx, &routeguide.Point{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "hello"; res.Name != want {
+		t.Errorf("got %v\nwant %v", res.Name, want)
+	}
+}
+
+func TestMatchReadingOwnRequests(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	ts := NewServer(t, "testdata/route_guide.proto")
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	var m *matcher
+	m = ts.Method("GetFeature").Match(func(r *Request) bool {
+		return len(m.Requests()) < 1
+	}).Response(map[string]any{"name": "first"})
+	ts.Method("GetFeature").Response(map[string]any{"name": "default"})
+
+	client := routeguide.NewRouteGuideClient(ts.Conn())
+	var got []string
+	for range 2 {
+		res, err := client.GetFeature(ctx, &routeguide.Point{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, res.Name)
+	}
+	if diff := cmp.Diff(got, []string{"first", "default"}); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestAddrAndOnRequest(t *testing.T) {
+	ctx := context.Background()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	_ = l.Close()
+
+	var got []string
+	ts := NewServer(t, "testdata/route_guide.proto", Addr(addr), OnRequest(func(req *Request) {
+		got = append(got, req.Method)
+	}))
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	if ts.Addr() != addr {
+		t.Errorf("got %v\nwant %v", ts.Addr(), addr)
+	}
+	ts.Method("GetFeature").Response(map[string]any{"name": "hello"})
+
+	client := routeguide.NewRouteGuideClient(ts.Conn())
+	if _, err := client.GetFeature(ctx, &routeguide.Point{}); err != nil {
+		t.Fatal(err)
+	}
+	// Unmatched requests are also passed to OnRequest.
+	stream, err := client.ListFeatures(ctx, &routeguide.Rectangle{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.NotFound {
+		t.Errorf("got %v\nwant %v", status.Code(err), codes.NotFound)
+	}
+	if diff := cmp.Diff(got, []string{"GetFeature", "ListFeatures"}); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestListener(t *testing.T) {
+	tests := []struct {
+		name string
+		opt  func(t *testing.T) Option
+	}{
+		{"UnixSocket", func(t *testing.T) Option { return UnixSocket(filepath.Join(t.TempDir(), "grpcstub.sock")) }},
+		{"InMemory", func(t *testing.T) Option { return InMemory() }},
+	}
+	ctx := context.Background()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := NewServer(t, "testdata/route_guide.proto", tt.opt(t))
+			t.Cleanup(func() {
+				ts.Close()
+			})
+			ts.Method("GetFeature").Response(map[string]any{"name": "hello"})
+
+			client := routeguide.NewRouteGuideClient(ts.Conn())
+			res, err := client.GetFeature(ctx, &routeguide.Point{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := "hello"; res.Name != want {
+				t.Errorf("got %v\nwant %v", res.Name, want)
+			}
+		})
+	}
+}
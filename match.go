@@ -0,0 +1,446 @@
+package grpcstub
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/goccy/go-yaml"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// MatchMessage create request matcher using partial message.
+// The request message matches when it contains all fields of partial.
+func (s *Server) MatchMessage(partial any) *matcher {
+	fn, err := messageMatchFunc(partial)
+	if err != nil {
+		s.t.Fatalf("failed to convert message: %v", err)
+	}
+	return s.newMatcher(fn)
+}
+
+// MatchMessage append request matcher using partial message.
+// The request message matches when it contains all fields of partial.
+func (m *matcher) MatchMessage(partial any) *matcher {
+	fn, err := messageMatchFunc(partial)
+	if err != nil {
+		m.t.Fatalf("failed to convert message: %v", err)
+	}
+	return m.Match(fn)
+}
+
+// MatchField create request matcher using the value of the field specified by path (e.g. "location.latitude", "points[0].latitude").
+func (s *Server) MatchField(path string, want any) *matcher {
+	fn, err := fieldMatchFunc(path, want)
+	if err != nil {
+		s.t.Fatalf("invalid field matcher: %v", err)
+	}
+	return s.newMatcher(fn)
+}
+
+// MatchField append request matcher using the value of the field specified by path (e.g. "location.latitude", "points[0].latitude").
+func (m *matcher) MatchField(path string, want any) *matcher {
+	fn, err := fieldMatchFunc(path, want)
+	if err != nil {
+		m.t.Fatalf("invalid field matcher: %v", err)
+	}
+	return m.Match(fn)
+}
+
+// MatchJSONPath create request matcher using the value selected by JSONPath expression (e.g. "$.location.latitude", "$..latitude").
+// Only the subset of JSONPath supported by the YAML path of goccy/go-yaml is available (no filters or slices).
+func (s *Server) MatchJSONPath(expr string, want any) *matcher {
+	fn, err := jsonPathMatchFunc(expr, want)
+	if err != nil {
+		s.t.Fatalf("invalid JSONPath matcher: %v", err)
+	}
+	return s.newMatcher(fn)
+}
+
+// MatchJSONPath append request matcher using the value selected by JSONPath expression (e.g. "$.location.latitude", "$..latitude").
+// Only the subset of JSONPath supported by the YAML path of goccy/go-yaml is available (no filters or slices).
+func (m *matcher) MatchJSONPath(expr string, want any) *matcher {
+	fn, err := jsonPathMatchFunc(expr, want)
+	if err != nil {
+		m.t.Fatalf("invalid JSONPath matcher: %v", err)
+	}
+	return m.Match(fn)
+}
+
+// MatchHeader create request matcher using header.
+// The header key is case-insensitive and the request matches when any of the values equals value.
+func (s *Server) MatchHeader(key, value string) *matcher {
+	return s.newMatcher(headerMatchFunc(key, value))
+}
+
+// MatchHeader append request matcher using header.
+// The header key is case-insensitive and the request matches when any of the values equals value.
+func (m *matcher) MatchHeader(key, value string) *matcher {
+	return m.Match(headerMatchFunc(key, value))
+}
+
+// MatchHeaderRegexp create request matcher using header matching regular expression.
+func (s *Server) MatchHeaderRegexp(key, expr string) *matcher {
+	fn, err := headerRegexpMatchFunc(key, expr)
+	if err != nil {
+		s.t.Fatalf("invalid header matcher: %v", err)
+	}
+	return s.newMatcher(fn)
+}
+
+// MatchHeaderRegexp append request matcher using header matching regular expression.
+func (m *matcher) MatchHeaderRegexp(key, expr string) *matcher {
+	fn, err := headerRegexpMatchFunc(key, expr)
+	if err != nil {
+		m.t.Fatalf("invalid header matcher: %v", err)
+	}
+	return m.Match(fn)
+}
+
+// MatchHeaderPresent create request matcher which matches when the header is present.
+func (s *Server) MatchHeaderPresent(key string) *matcher {
+	return s.newMatcher(headerPresentMatchFunc(key))
+}
+
+// MatchHeaderPresent append request matcher which matches when the header is present.
+func (m *matcher) MatchHeaderPresent(key string) *matcher {
+	return m.Match(headerPresentMatchFunc(key))
+}
+
+// MatchHeaderAbsent create request matcher which matches when the header is absent.
+func (s *Server) MatchHeaderAbsent(key string) *matcher {
+	return s.newMatcher(headerAbsentMatchFunc(key))
+}
+
+// MatchHeaderAbsent append request matcher which matches when the header is absent.
+func (m *matcher) MatchHeaderAbsent(key string) *matcher {
+	return m.Match(headerAbsentMatchFunc(key))
+}
+
+// MatchClientCert create request matcher using the client certificate of mutual TLS.
+// The request matches when the subject common name or any of the subject alternative names (DNS, email, IP and URI) equals name.
+func (s *Server) MatchClientCert(name string) *matcher {
+	return s.newMatcher(clientCertMatchFunc(name))
+}
+
+// MatchClientCert append request matcher using the client certificate of mutual TLS.
+// The request matches when the subject common name or any of the subject alternative names (DNS, email, IP and URI) equals name.
+func (m *matcher) MatchClientCert(name string) *matcher {
+	return m.Match(clientCertMatchFunc(name))
+}
+
+func (s *Server) newMatcher(fn matchFunc) *matcher {
+	m := &matcher{
+		matchFuncs: []matchFunc{fn},
+		s:          s,
+		t:          s.t,
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addMatcher(m)
+	return m
+}
+
+func messageMatchFunc(partial any) (matchFunc, error) {
+	want, err := normalizeValue(partial)
+	if err != nil {
+		return nil, err
+	}
+	return func(req *Request) bool {
+		return matchValue(map[string]any(req.Message), want, req.messageDescriptor(), nil)
+	}, nil
+}
+
+func fieldMatchFunc(path string, want any) (matchFunc, error) {
+	keys, err := parseFieldPath(path)
+	if err != nil {
+		return nil, err
+	}
+	w, err := normalizeValue(want)
+	if err != nil {
+		return nil, err
+	}
+	return func(req *Request) bool {
+		got, ok := lookupField(map[string]any(req.Message), keys)
+		if !ok {
+			return false
+		}
+		return matchValue(got, w, nil, lookupFieldDescriptor(req.messageDescriptor(), keys))
+	}, nil
+}
+
+func jsonPathMatchFunc(expr string, want any) (matchFunc, error) {
+	p, err := yaml.PathString(expr)
+	if err != nil {
+		return nil, err
+	}
+	w, err := normalizeValue(want)
+	if err != nil {
+		return nil, err
+	}
+	return func(req *Request) bool {
+		var v any
+		if err := p.Filter(map[string]any(req.Message), &v); err != nil {
+			return false
+		}
+		got, err := normalizeValue(v)
+		if err != nil {
+			return false
+		}
+		// The field of the selected value is unknown, so 64-bit integers must be given as strings.
+		return matchValue(got, w, nil, nil)
+	}, nil
+}
+
+func headerMatchFunc(key, value string) matchFunc {
+	return func(req *Request) bool {
+		return slices.Contains(headerValues(req, key), value)
+	}
+}
+
+func headerRegexpMatchFunc(key, expr string) (matchFunc, error) {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+	return func(req *Request) bool {
+		return slices.ContainsFunc(headerValues(req, key), re.MatchString)
+	}, nil
+}
+
+func headerPresentMatchFunc(key string) matchFunc {
+	return func(req *Request) bool {
+		return len(headerValues(req, key)) > 0
+	}
+}
+
+func headerAbsentMatchFunc(key string) matchFunc {
+	return func(req *Request) bool {
+		return len(headerValues(req, key)) == 0
+	}
+}
+
+func clientCertMatchFunc(name string) matchFunc {
+	return func(req *Request) bool {
+		if len(req.PeerCertificates) == 0 {
+			return false
+		}
+		return slices.Contains(certificateNames(req.PeerCertificates[0]), name)
+	}
+}
+
+// certificateNames returns the subject common name and the subject alternative names of the certificate.
+func certificateNames(cert *x509.Certificate) []string {
+	names := []string{cert.Subject.CommonName}
+	names = append(names, cert.DNSNames...)
+	names = append(names, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	for _, u := range cert.URIs {
+		names = append(names, u.String())
+	}
+	return names
+}
+
+// headerValues returns the values of the header, looking up the key case-insensitively.
+func headerValues(req *Request, key string) []string {
+	var values []string
+	for k, v := range req.Headers {
+		if strings.EqualFold(k, key) {
+			values = append(values, v...)
+		}
+	}
+	return values
+}
+
+// normalizeValue converts v to the same representation as Request.Message (the protojson form decoded by encoding/json).
+func normalizeValue(v any) (any, error) {
+	if pm, ok := v.(protoreflect.ProtoMessage); ok {
+		m, err := MarshalProtoMessage(pm)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]any(m), nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var n any
+	if err := json.Unmarshal(b, &n); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// matchValue reports whether got contains want.
+// Maps are compared as subsets, lists are compared element by element.
+// md and fd describe got (either may be nil when unknown). They are used to look up fields of want by the JSON names
+// and to compare numbers with 64-bit integer fields encoded as strings.
+func matchValue(got, want any, md protoreflect.MessageDescriptor, fd protoreflect.FieldDescriptor) bool {
+	switch w := want.(type) {
+	case map[string]any:
+		g, ok := got.(map[string]any)
+		if !ok {
+			return false
+		}
+		for k, wv := range w {
+			cfd := childFieldDescriptor(md, fd, k)
+			key := k
+			if cfd != nil && (fd == nil || !fd.IsMap()) {
+				// Request.Message is keyed by the proto names.
+				key = string(cfd.Name())
+			}
+			gv, ok := g[key]
+			if !ok {
+				return false
+			}
+			if !matchValue(gv, wv, nil, cfd) {
+				return false
+			}
+		}
+		return true
+	case []any:
+		g, ok := got.([]any)
+		if !ok || len(g) != len(w) {
+			return false
+		}
+		for i := range w {
+			if !matchValue(g[i], w[i], md, fd) {
+				return false
+			}
+		}
+		return true
+	case float64:
+		switch g := got.(type) {
+		case float64:
+			return g == w
+		case string:
+			// 64-bit integers are encoded as strings in the protojson form.
+			if !is64BitIntegerField(fd) {
+				return false
+			}
+			f, err := strconv.ParseFloat(g, 64)
+			return err == nil && f == w
+		}
+		return false
+	default:
+		return reflect.DeepEqual(got, want)
+	}
+}
+
+// childFieldDescriptor returns the descriptor of the field key of the message described by md or fd, or nil when it is unknown.
+func childFieldDescriptor(md protoreflect.MessageDescriptor, fd protoreflect.FieldDescriptor, key string) protoreflect.FieldDescriptor {
+	if fd != nil {
+		if fd.IsMap() {
+			return fd.MapValue()
+		}
+		md = fd.Message()
+	}
+	if md == nil || strings.HasPrefix(string(md.FullName()), "google.protobuf.") {
+		// Well-known types have their own JSON representation.
+		return nil
+	}
+	if child := md.Fields().ByName(protoreflect.Name(key)); child != nil {
+		return child
+	}
+	return md.Fields().ByJSONName(key)
+}
+
+// lookupFieldDescriptor returns the descriptor of the field selected by keys, or nil when it is unknown.
+func lookupFieldDescriptor(md protoreflect.MessageDescriptor, keys []any) protoreflect.FieldDescriptor {
+	var fd protoreflect.FieldDescriptor
+	for _, k := range keys {
+		name, ok := k.(string)
+		if !ok {
+			// The index selects the element of the repeated field.
+			continue
+		}
+		fd = childFieldDescriptor(md, fd, name)
+		if fd == nil {
+			return nil
+		}
+		md = nil
+	}
+	return fd
+}
+
+func is64BitIntegerField(fd protoreflect.FieldDescriptor) bool {
+	if fd == nil {
+		return false
+	}
+	switch fd.Kind() {
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return true
+	}
+	return false
+}
+
+// parseFieldPath parses path like "location.latitude" or "points[0].latitude" into keys (string or int).
+func parseFieldPath(path string) ([]any, error) {
+	if path == "" {
+		return nil, fmt.Errorf("empty field path")
+	}
+	var keys []any
+	for _, seg := range strings.Split(path, ".") {
+		name, rest, _ := strings.Cut(seg, "[")
+		if strings.Contains(name, "]") {
+			return nil, fmt.Errorf("invalid field path: %s", path)
+		}
+		if name != "" {
+			keys = append(keys, name)
+		}
+		if rest == "" {
+			if name == "" {
+				return nil, fmt.Errorf("invalid field path: %s", path)
+			}
+			continue
+		}
+		rest = "[" + rest
+		for rest != "" {
+			if !strings.HasPrefix(rest, "[") {
+				return nil, fmt.Errorf("invalid field path: %s", path)
+			}
+			end := strings.Index(rest, "]")
+			if end < 0 {
+				return nil, fmt.Errorf("invalid field path: %s", path)
+			}
+			idx, err := strconv.Atoi(rest[1:end])
+			if err != nil || idx < 0 {
+				return nil, fmt.Errorf("invalid index in field path: %s", path)
+			}
+			keys = append(keys, idx)
+			rest = rest[end+1:]
+		}
+	}
+	return keys, nil
+}
+
+func lookupField(v any, keys []any) (any, bool) {
+	for _, k := range keys {
+		switch kk := k.(type) {
+		case string:
+			m, ok := v.(map[string]any)
+			if !ok {
+				return nil, false
+			}
+			v, ok = m[kk]
+			if !ok {
+				return nil, false
+			}
+		case int:
+			l, ok := v.([]any)
+			if !ok || kk >= len(l) {
+				return nil, false
+			}
+			v = l[kk]
+		}
+	}
+	return v, true
+}
@@ -0,0 +1,245 @@
+package grpcstub
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/k1LoW/grpcstub/testdata/hello"
+	"github.com/k1LoW/grpcstub/testdata/routeguide"
+	"google.golang.org/grpc/metadata"
+)
+
+func TestMatchMessage(t *testing.T) {
+	tests := []struct {
+		partial any
+		want    string
+	}{
+		{map[string]any{"latitude": 10}, "hello"},
+		{map[string]any{"latitude": 10, "longitude": 13}, "hello"},
+		{&routeguide.Point{Latitude: 10, Longitude: 13}, "hello"},
+		{map[string]any{"latitude": 99}, "default"},
+		{map[string]any{"altitude": 10}, "default"},
+	}
+	ctx := context.Background()
+	for i, tt := range tests {
+		t.Run(fmt.Sprintf("%d", i), func(t *testing.T) {
+			ts := NewServer(t, "testdata/route_guide.proto")
+			t.Cleanup(func() {
+				ts.Close()
+			})
+			ts.Method("GetFeature").MatchMessage(tt.partial).Response(map[string]any{"name": "hello"})
+			ts.Method("GetFeature").Response(map[string]any{"name": "default"})
+
+			client := routeguide.NewRouteGuideClient(ts.Conn())
+			res, err := client.GetFeature(ctx, &routeguide.Point{
+				Latitude:  10,
+				Longitude: 13,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := res.Name; got != tt.want {
+				t.Errorf("got %v\nwant %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchField(t *testing.T) {
+	tests := []struct {
+		path string
+		want any
+		ok   bool
+	}{
+		{"lo.latitude", 10, true},
+		{"hi.longitude", 7, true},
+		{"lo.latitude", 11, false},
+		{"lo.altitude", 10, false},
+		{"lo", map[string]any{"longitude": 2}, true},
+		{"lo.latitude", "10", false},
+	}
+	ctx := context.Background()
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s=%v", tt.path, tt.want), func(t *testing.T) {
+			ts := NewServer(t, "testdata/route_guide.proto")
+			t.Cleanup(func() {
+				ts.Close()
+			})
+			ts.MatchField(tt.path, tt.want).Response(map[string]any{"name": "hello"})
+
+			client := routeguide.NewRouteGuideClient(ts.Conn())
+			stream, err := client.ListFeatures(ctx, &routeguide.Rectangle{
+				Lo: &routeguide.Point{Latitude: 10, Longitude: 2},
+				Hi: &routeguide.Point{Latitude: 20, Longitude: 7},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = stream.Recv()
+			if got := err == nil; got != tt.ok {
+				t.Errorf("got %v\nwant %v", got, tt.ok)
+			}
+		})
+	}
+}
+
+func TestMatchFieldInt64(t *testing.T) {
+	ctx := context.Background()
+	ts := NewServer(t, "testdata/hello.proto")
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	ts.Method("Hello").MatchField("num", 35).Response(map[string]any{"message": "hello"})
+
+	client := hello.NewGrpcTestServiceClient(ts.Conn())
+	res, err := client.Hello(ctx, &hello.HelloRequest{Name: "alice", Num: 35})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "hello"; res.Message != want {
+		t.Errorf("got %v\nwant %v", res.Message, want)
+	}
+}
+
+func TestMatchInt64Coercion(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func(ts *Server) *matcher
+		ok   bool
+	}{
+		{"int64 field with number", func(ts *Server) *matcher { return ts.MatchField("num", 35) }, true},
+		{"int64 field with string", func(ts *Server) *matcher { return ts.MatchField("num", "35") }, true},
+		{"int64 field in message", func(ts *Server) *matcher { return ts.MatchMessage(map[string]any{"num": 35}) }, true},
+		{"string field with number", func(ts *Server) *matcher { return ts.MatchField("name", 10) }, false},
+		{"string field in message", func(ts *Server) *matcher { return ts.MatchMessage(map[string]any{"name": 10}) }, false},
+	}
+	ctx := context.Background()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := NewServer(t, "testdata/hello.proto")
+			t.Cleanup(func() {
+				ts.Close()
+			})
+			tt.fn(ts).Response(map[string]any{"message": "hello"})
+
+			client := hello.NewGrpcTestServiceClient(ts.Conn())
+			_, err := client.Hello(ctx, &hello.HelloRequest{Name: "10", Num: 35})
+			if got := err == nil; got != tt.ok {
+				t.Errorf("got %v\nwant %v", got, tt.ok)
+			}
+		})
+	}
+}
+
+func TestMatchJSONPath(t *testing.T) {
+	tests := []struct {
+		expr string
+		want any
+		ok   bool
+	}{
+		{"$.lo.latitude", 10, true},
+		{"$.hi", map[string]any{"latitude": 20, "longitude": 7}, true},
+		{"$..latitude", []any{20, 10}, true}, // keys are visited in sorted order
+		{"$.lo.latitude", 20, false},
+		{"$.lo.altitude", 10, false},
+	}
+	ctx := context.Background()
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			ts := NewServer(t, "testdata/route_guide.proto")
+			t.Cleanup(func() {
+				ts.Close()
+			})
+			ts.Method("ListFeatures").MatchJSONPath(tt.expr, tt.want).Response(map[string]any{"name": "hello"})
+
+			client := routeguide.NewRouteGuideClient(ts.Conn())
+			stream, err := client.ListFeatures(ctx, &routeguide.Rectangle{
+				Lo: &routeguide.Point{Latitude: 10, Longitude: 2},
+				Hi: &routeguide.Point{Latitude: 20, Longitude: 7},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = stream.Recv()
+			if got := err == nil; got != tt.ok {
+				t.Errorf("got %v\nwant %v", got, tt.ok)
+			}
+		})
+	}
+}
+
+func TestParseFieldPath(t *testing.T) {
+	tests := []struct {
+		path    string
+		want    []any
+		wantErr bool
+	}{
+		{"name", []any{"name"}, false},
+		{"location.latitude", []any{"location", "latitude"}, false},
+		{"points[0].latitude", []any{"points", 0, "latitude"}, false},
+		{"matrix[1][2]", []any{"matrix", 1, 2}, false},
+		{"", nil, true},
+		{"points[a]", nil, true},
+		{"points[0", nil, true},
+		{"a..b", nil, true},
+		{"a]", nil, true},
+		{"points[0]x", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			got, err := parseFieldPath(tt.path)
+			if err != nil {
+				if !tt.wantErr {
+					t.Errorf("got error: %v", err)
+				}
+				return
+			}
+			if tt.wantErr {
+				t.Error("want error")
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("got %v\nwant %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchHeader(t *testing.T) {
+	tests := []struct {
+		name string
+		fn   func(ts *Server) *matcher
+		md   []string
+		want string
+	}{
+		{"MatchHeader", func(ts *Server) *matcher { return ts.MatchHeader("X-Tenant", "alice") }, []string{"x-tenant", "alice"}, "hello"},
+		{"MatchHeader multi values", func(ts *Server) *matcher { return ts.MatchHeader("x-tenant", "bob") }, []string{"x-tenant", "alice", "x-tenant", "bob"}, "hello"},
+		{"MatchHeader unmatched", func(ts *Server) *matcher { return ts.MatchHeader("x-tenant", "bob") }, []string{"x-tenant", "alice"}, "default"},
+		{"MatchHeaderRegexp", func(ts *Server) *matcher { return ts.MatchHeaderRegexp("Authorization", `^Bearer\s+`) }, []string{"authorization", "Bearer xxx"}, "hello"},
+		{"MatchHeaderRegexp unmatched", func(ts *Server) *matcher { return ts.MatchHeaderRegexp("authorization", `^Bearer\s+`) }, []string{"authorization", "Basic xxx"}, "default"},
+		{"MatchHeaderPresent", func(ts *Server) *matcher { return ts.MatchHeaderPresent("X-Tenant") }, []string{"x-tenant", "alice"}, "hello"},
+		{"MatchHeaderPresent unmatched", func(ts *Server) *matcher { return ts.MatchHeaderPresent("x-tenant") }, []string{}, "default"},
+		{"MatchHeaderAbsent", func(ts *Server) *matcher { return ts.MatchHeaderAbsent("x-tenant") }, []string{}, "hello"},
+		{"MatchHeaderAbsent unmatched", func(ts *Server) *matcher { return ts.MatchHeaderAbsent("X-Tenant") }, []string{"x-tenant", "alice"}, "default"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := NewServer(t, "testdata/route_guide.proto")
+			t.Cleanup(func() {
+				ts.Close()
+			})
+			tt.fn(ts).Method("GetFeature").Response(map[string]any{"name": "hello"})
+			ts.Method("GetFeature").Response(map[string]any{"name": "default"})
+
+			client := routeguide.NewRouteGuideClient(ts.Conn())
+			ctx := metadata.AppendToOutgoingContext(context.Background(), tt.md...)
+			res, err := client.GetFeature(ctx, &routeguide.Point{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := res.Name; got != tt.want {
+				t.Errorf("got %v\nwant %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,442 @@
+package grpcstub
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bufbuild/protocompile"
+	"github.com/bufbuild/protocompile/linker"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+const (
+	operationsProtoPath          = "google/longrunning/operations.proto"
+	operationsServiceName        = "google.longrunning.Operations"
+	operationInfoExtensionName   = "google.longrunning.operation_info"
+	operationsWaitDefaultTimeout = 30 * time.Second
+)
+
+//go:embed proto/google/longrunning/operations.proto
+var operationsProto string
+
+var (
+	operationsFds     linker.Files
+	operationsFdsErr  error
+	operationsFdsOnce sync.Once
+)
+
+// operation is the long-running operation emulated by the server.
+type operation struct {
+	name      string
+	metadata  Message
+	response  Message
+	err       *status.Status
+	polls     int
+	donePolls int
+	doneAt    time.Time
+	canceled  bool
+}
+
+type operationConfig struct {
+	polls    int
+	duration time.Duration
+	metadata any
+}
+
+// OperationOption is the option of the operation returned by ResponseOperation and ResponseOperationError.
+type OperationOption func(*operationConfig)
+
+// DoneAfterPolls set the number of GetOperation calls until the operation is done.
+func DoneAfterPolls(n int) OperationOption {
+	return func(c *operationConfig) {
+		c.polls = n
+	}
+}
+
+// DoneAfter set the duration until the operation is done.
+func DoneAfter(d time.Duration) OperationOption {
+	return func(c *operationConfig) {
+		c.duration = d
+	}
+}
+
+// OperationMetadata set the metadata of the operation.
+// The metadata is packed into google.protobuf.Any using "@type" of the metadata or metadata_type of google.longrunning.operation_info option.
+func OperationMetadata(metadata any) OperationOption {
+	return func(c *operationConfig) {
+		c.metadata = metadata
+	}
+}
+
+// ResponseOperation set handler which return google.longrunning.Operation that completes with the response message.
+// The message is packed into google.protobuf.Any using "@type" of the message or response_type of google.longrunning.operation_info option.
+// The operation can be polled by google.longrunning.Operations service enabled by EnableOperations.
+func (m *matcher) ResponseOperation(message any, opts ...OperationOption) *matcher {
+	mm, err := convertMessage(message)
+	if err != nil {
+		m.t.Fatalf("failed to convert message: %v", err)
+	}
+	return m.responseOperation(mm, nil, opts...)
+}
+
+// ResponseOperationError set handler which return google.longrunning.Operation that completes with the error status.
+func (m *matcher) ResponseOperationError(s *status.Status, opts ...OperationOption) *matcher {
+	return m.responseOperation(nil, s, opts...)
+}
+
+func (m *matcher) responseOperation(message Message, st *status.Status, opts ...OperationOption) *matcher {
+	c := &operationConfig{}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.polls < 0 {
+		m.t.Fatalf("invalid polls: %d", c.polls)
+	}
+	if c.duration < 0 {
+		m.t.Fatalf("invalid duration: %s", c.duration)
+	}
+	var metadata Message
+	if c.metadata != nil {
+		mm, err := convertMessage(c.metadata)
+		if err != nil {
+			m.t.Fatalf("failed to convert metadata: %v", err)
+		}
+		metadata = mm
+	}
+	prev := m.handler
+	m.handler = func(req *Request, md protoreflect.MethodDescriptor) *Response {
+		var res *Response
+		if prev == nil {
+			res = NewResponse()
+		} else {
+			res = prev(req, md)
+		}
+		op, err := m.s.newOperation(md, message, metadata, st, c)
+		if err != nil {
+			res.Status = status.New(codes.Internal, err.Error())
+			return res
+		}
+		m.s.operationMu.Lock()
+		om, err := m.s.operationMessage(op)
+		m.s.operationMu.Unlock()
+		if err != nil {
+			res.Status = status.New(codes.Internal, err.Error())
+			return res
+		}
+		res.Messages = append(res.Messages, om)
+		res.resolver = m.s.resolver()
+		return res
+	}
+	return m
+}
+
+func (s *Server) newOperation(md protoreflect.MethodDescriptor, response, metadata Message, st *status.Status, c *operationConfig) (*operation, error) {
+	name, err := newUUID()
+	if err != nil {
+		return nil, err
+	}
+	op := &operation{
+		name:      "operations/" + name,
+		err:       st,
+		donePolls: c.polls,
+		doneAt:    time.Now().Add(c.duration),
+	}
+	if response != nil {
+		op.response, err = s.packAny(md, response, "response_type")
+		if err != nil {
+			return nil, err
+		}
+	}
+	if metadata != nil {
+		op.metadata, err = s.packAny(md, metadata, "metadata_type")
+		if err != nil {
+			return nil, err
+		}
+	}
+	s.operationMu.Lock()
+	defer s.operationMu.Unlock()
+	s.operations = append(s.operations, op)
+	return op, nil
+}
+
+// packAny returns the message in the JSON form of google.protobuf.Any.
+// The type is "@type" of the message, or the field of google.longrunning.operation_info option of the method.
+func (s *Server) packAny(md protoreflect.MethodDescriptor, m Message, field string) (Message, error) {
+	packed := Message{}
+	for k, v := range m {
+		packed[k] = v
+	}
+	if _, ok := packed["@type"]; !ok {
+		info, err := s.methodOption(md, operationInfoExtensionName)
+		if err != nil {
+			return nil, err
+		}
+		t, _ := info[field].(string)
+		if t == "" {
+			return nil, fmt.Errorf("%s of %s is not specified: set \"@type\" to the message", field, md.FullName())
+		}
+		if !strings.Contains(t, ".") {
+			// The type in the same package of the method.
+			t = string(md.ParentFile().Package().Append(protoreflect.Name(t)))
+		}
+		packed["@type"] = "type.googleapis.com/" + t
+	}
+	b, err := json.Marshal(packed)
+	if err != nil {
+		return nil, err
+	}
+	if err := (protojson.UnmarshalOptions{Resolver: s.resolver()}).Unmarshal(b, &anypb.Any{}); err != nil {
+		return nil, fmt.Errorf("invalid %s for %s: %w", field, md.FullName(), err)
+	}
+	return packed, nil
+}
+
+// operationMessage returns google.longrunning.Operation of the operation.
+func (s *Server) operationMessage(op *operation) (Message, error) {
+	m := Message{"name": op.name, "done": false}
+	if op.metadata != nil {
+		m["metadata"] = map[string]any(op.metadata)
+	}
+	if !op.done(time.Now()) {
+		return m, nil
+	}
+	m["done"] = true
+	st := op.err
+	if op.canceled {
+		st = status.New(codes.Canceled, "operation was canceled")
+	}
+	if st != nil {
+		b, err := (protojson.MarshalOptions{UseProtoNames: true, Resolver: s.resolver()}).Marshal(st.Proto())
+		if err != nil {
+			return nil, err
+		}
+		e := map[string]any{}
+		if err := json.Unmarshal(b, &e); err != nil {
+			return nil, err
+		}
+		m["error"] = e
+		return m, nil
+	}
+	if op.response != nil {
+		m["response"] = map[string]any(op.response)
+	}
+	return m, nil
+}
+
+func (op *operation) done(now time.Time) bool {
+	return op.canceled || (op.polls >= op.donePolls && !now.Before(op.doneAt))
+}
+
+// operationsServiceDescriptor returns the descriptor of google.longrunning.Operations.
+// The descriptor in the compiled protos is preferred to the embedded one.
+func (s *Server) operationsServiceDescriptor() (protoreflect.ServiceDescriptor, error) {
+	if d, err := s.fds.AsResolver().FindDescriptorByName(operationsServiceName); err == nil {
+		if sd, ok := d.(protoreflect.ServiceDescriptor); ok {
+			return sd, nil
+		}
+	}
+	if d, err := protoregistry.GlobalFiles.FindDescriptorByName(operationsServiceName); err == nil {
+		if sd, ok := d.(protoreflect.ServiceDescriptor); ok {
+			return sd, nil
+		}
+	}
+	operationsFdsOnce.Do(func() {
+		comp := protocompile.Compiler{
+			Resolver: protocompile.WithStandardImports(protocompile.CompositeResolver{
+				&protocompile.SourceResolver{
+					Accessor: protocompile.SourceAccessorFromMap(map[string]string{
+						operationsProtoPath: operationsProto,
+					}),
+				},
+				// google/rpc/status.proto
+				protocompile.ResolverFunc(func(path string) (protocompile.SearchResult, error) {
+					fd, err := protoregistry.GlobalFiles.FindFileByPath(path)
+					if err != nil {
+						return protocompile.SearchResult{}, err
+					}
+					return protocompile.SearchResult{Desc: fd}, nil
+				}),
+			}),
+		}
+		operationsFds, operationsFdsErr = comp.Compile(context.Background(), operationsProtoPath)
+		if operationsFdsErr != nil {
+			return
+		}
+		operationsFdsErr = registerFiles(operationsFds)
+	})
+	if operationsFdsErr != nil {
+		return nil, operationsFdsErr
+	}
+	return operationsFds[0].Services().ByName("Operations"), nil
+}
+
+func (s *Server) registerOperationsServer() error {
+	sd, err := s.operationsServiceDescriptor()
+	if err != nil {
+		return err
+	}
+	handlers := map[protoreflect.Name]adminHandlerFunc{
+		"ListOperations":  s.listOperations,
+		"GetOperation":    s.getOperation,
+		"DeleteOperation": s.deleteOperation,
+		"CancelOperation": s.cancelOperation,
+		"WaitOperation":   s.waitOperation,
+	}
+	gsd := &grpc.ServiceDesc{
+		ServiceName: string(sd.FullName()),
+		HandlerType: nil,
+		Metadata:    sd.ParentFile().Path(),
+	}
+	for i := 0; i < sd.Methods().Len(); i++ {
+		md := sd.Methods().Get(i)
+		fn, ok := handlers[md.Name()]
+		if !ok {
+			continue
+		}
+		gsd.Methods = append(gsd.Methods, grpc.MethodDesc{
+			MethodName: string(md.Name()),
+			Handler:    createAdminHandler(md, fn, s.resolver()),
+		})
+	}
+	s.server.RegisterService(gsd, nil)
+	return nil
+}
+
+func (s *Server) findOperation(in Message) (*operation, error) {
+	name, _ := in["name"].(string)
+	for _, op := range s.operations {
+		if op.name == name {
+			return op, nil
+		}
+	}
+	return nil, status.Errorf(codes.NotFound, "operation %s not found", name)
+}
+
+func (s *Server) getOperation(_ context.Context, in Message) (any, error) {
+	s.operationMu.Lock()
+	defer s.operationMu.Unlock()
+	op, err := s.findOperation(in)
+	if err != nil {
+		return nil, err
+	}
+	op.polls++
+	return s.operationMessage(op)
+}
+
+func (s *Server) listOperations(_ context.Context, in Message) (any, error) {
+	pageSize := 0
+	if v, ok := in["page_size"].(float64); ok {
+		pageSize = int(v)
+	}
+	offset := 0
+	if token, _ := in["page_token"].(string); token != "" {
+		v, err := strconv.Atoi(token)
+		if err != nil || v < 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid page_token: %s", token)
+		}
+		offset = v
+	}
+	s.operationMu.Lock()
+	defer s.operationMu.Unlock()
+	offset = min(offset, len(s.operations))
+	end := len(s.operations)
+	if pageSize > 0 {
+		end = min(offset+pageSize, len(s.operations))
+	}
+	ops := []any{}
+	for _, op := range s.operations[offset:end] {
+		om, err := s.operationMessage(op)
+		if err != nil {
+			return nil, err
+		}
+		ops = append(ops, map[string]any(om))
+	}
+	res := map[string]any{"operations": ops}
+	if end < len(s.operations) {
+		res["next_page_token"] = strconv.Itoa(end)
+	}
+	return res, nil
+}
+
+func (s *Server) deleteOperation(_ context.Context, in Message) (any, error) {
+	s.operationMu.Lock()
+	defer s.operationMu.Unlock()
+	op, err := s.findOperation(in)
+	if err != nil {
+		return nil, err
+	}
+	for i, o := range s.operations {
+		if o == op {
+			s.operations = append(s.operations[:i], s.operations[i+1:]...)
+			break
+		}
+	}
+	return nil, nil
+}
+
+func (s *Server) cancelOperation(_ context.Context, in Message) (any, error) {
+	s.operationMu.Lock()
+	defer s.operationMu.Unlock()
+	op, err := s.findOperation(in)
+	if err != nil {
+		return nil, err
+	}
+	if !op.done(time.Now()) {
+		op.canceled = true
+	}
+	return nil, nil
+}
+
+// waitOperation waits until the operation is done, the timeout or the cancellation of the call.
+// The operation is completed regardless of the polls when the duration has passed.
+func (s *Server) waitOperation(ctx context.Context, in Message) (any, error) {
+	timeout := operationsWaitDefaultTimeout
+	if v, ok := in["timeout"].(string); ok && v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid timeout: %s", v)
+		}
+		timeout = d
+	}
+	s.operationMu.Lock()
+	op, err := s.findOperation(in)
+	if err != nil {
+		s.operationMu.Unlock()
+		return nil, err
+	}
+	wait := time.Until(op.doneAt)
+	s.operationMu.Unlock()
+
+	completed := true
+	if wait > 0 {
+		if timeout < wait {
+			wait = timeout
+			completed = false
+		}
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return nil, status.FromContextError(ctx.Err()).Err()
+		case <-timer.C:
+		}
+	}
+	s.operationMu.Lock()
+	defer s.operationMu.Unlock()
+	if completed {
+		op.polls = max(op.polls, op.donePolls)
+	}
+	return s.operationMessage(op)
+}
@@ -0,0 +1,209 @@
+package grpcstub
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func TestResponseOperation(t *testing.T) {
+	ts := NewServer(t, "testdata/lro/exporter.proto", ImportPath("testdata/lro", "proto"), EnableOperations())
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	ts.Method("ExportBooks").ResponseOperation(map[string]any{"uri": "gs://bucket/books.json", "count": 3}, DoneAfterPolls(2), OperationMetadata(map[string]any{"progress_percent": 50}))
+	ts.Method("ImportBooks").Match(func(req *Request) bool {
+		return req.Message["uri"] == "invalid"
+	}).ResponseOperationError(status.New(codes.InvalidArgument, "invalid uri"))
+	ts.Method("ImportBooks").ResponseOperation(map[string]any{"@type": "type.googleapis.com/exporter.ImportBooksResponse", "count": 3}, DoneAfter(time.Hour))
+
+	call := func(t *testing.T, service, method string, in Message) Message {
+		t.Helper()
+		res, err := invoke(ts, service, method, in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	t.Run("DoneAfterPolls", func(t *testing.T) {
+		op := call(t, "exporter.Exporter", "ExportBooks", Message{"parent": "shelves/fiction"})
+		if got, want := op["done"], false; got != want {
+			t.Errorf("got %v\nwant %v", got, want)
+		}
+		wantMetadata := map[string]any{"@type": "type.googleapis.com/exporter.ExportBooksMetadata", "progress_percent": float64(50)}
+		if diff := cmp.Diff(op["metadata"], any(wantMetadata)); diff != "" {
+			t.Error(diff)
+		}
+		name := op["name"]
+		for _, want := range []bool{false, true, true} {
+			op = call(t, "google.longrunning.Operations", "GetOperation", Message{"name": name})
+			if got := op["done"]; got != want {
+				t.Errorf("got %v\nwant %v", got, want)
+			}
+		}
+		wantResponse := map[string]any{"@type": "type.googleapis.com/exporter.ExportBooksResponse", "uri": "gs://bucket/books.json", "count": "3"}
+		if diff := cmp.Diff(op["response"], any(wantResponse)); diff != "" {
+			t.Error(diff)
+		}
+	})
+
+	t.Run("Error", func(t *testing.T) {
+		op := call(t, "exporter.Exporter", "ImportBooks", Message{"uri": "invalid"})
+		if got, want := op["done"], true; got != want {
+			t.Errorf("got %v\nwant %v", got, want)
+		}
+		want := map[string]any{"code": float64(codes.InvalidArgument), "message": "invalid uri", "details": []any{}}
+		if diff := cmp.Diff(op["error"], any(want)); diff != "" {
+			t.Error(diff)
+		}
+	})
+
+	t.Run("DoneAfter", func(t *testing.T) {
+		op := call(t, "exporter.Exporter", "ImportBooks", Message{"uri": "gs://bucket/books.json"})
+		op = call(t, "google.longrunning.Operations", "WaitOperation", Message{"name": op["name"], "timeout": "0.1s"})
+		if got, want := op["done"], false; got != want {
+			t.Errorf("got %v\nwant %v", got, want)
+		}
+	})
+
+	t.Run("Wait timeout keeps polls", func(t *testing.T) {
+		ts.Prepend().Method("ExportBooks").Match(func(req *Request) bool {
+			return req.Message["parent"] == "shelves/wait"
+		}).ResponseOperation(map[string]any{"uri": "gs://bucket/books.json"}, DoneAfterPolls(1), DoneAfter(time.Hour))
+		op := call(t, "exporter.Exporter", "ExportBooks", Message{"parent": "shelves/wait"})
+		name := op["name"]
+		op = call(t, "google.longrunning.Operations", "WaitOperation", Message{"name": name, "timeout": "0.05s"})
+		if got, want := op["done"], false; got != want {
+			t.Errorf("got %v\nwant %v", got, want)
+		}
+		s := ts.operations[len(ts.operations)-1]
+		if got, want := s.polls, 0; got != want {
+			t.Errorf("got %v\nwant %v", got, want)
+		}
+	})
+
+	t.Run("Wait canceled by the client", func(t *testing.T) {
+		op := call(t, "exporter.Exporter", "ImportBooks", Message{"uri": "gs://bucket/books.json"})
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		t.Cleanup(cancel)
+		mds, err := ts.findMethods("google.longrunning.Operations", "WaitOperation")
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := dynamicpb.NewMessage(mds[0].Input())
+		if err := UnmarshalProtoMessage(Message{"name": op["name"]}, req); err != nil {
+			t.Fatal(err)
+		}
+		start := time.Now()
+		err = ts.Conn().Invoke(ctx, "/google.longrunning.Operations/WaitOperation", req, dynamicpb.NewMessage(mds[0].Output()))
+		if status.Code(err) != codes.DeadlineExceeded {
+			t.Errorf("got %v\nwant %v", status.Code(err), codes.DeadlineExceeded)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("got %v\nwant returned soon after the deadline", elapsed)
+		}
+	})
+
+	t.Run("Cancel and Delete", func(t *testing.T) {
+		op := call(t, "exporter.Exporter", "ImportBooks", Message{"uri": "gs://bucket/books.json"})
+		name := op["name"]
+		call(t, "google.longrunning.Operations", "CancelOperation", Message{"name": name})
+		op = call(t, "google.longrunning.Operations", "GetOperation", Message{"name": name})
+		if got, want := op["done"], true; got != want {
+			t.Errorf("got %v\nwant %v", got, want)
+		}
+		if got, want := op["error"].(map[string]any)["code"], float64(codes.Canceled); got != want {
+			t.Errorf("got %v\nwant %v", got, want)
+		}
+		call(t, "google.longrunning.Operations", "DeleteOperation", Message{"name": name})
+		if _, err := invoke(ts, "google.longrunning.Operations", "GetOperation", Message{"name": name}); status.Code(err) != codes.NotFound {
+			t.Errorf("got %v\nwant %v", status.Code(err), codes.NotFound)
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		res := call(t, "google.longrunning.Operations", "ListOperations", Message{"page_size": 2})
+		if got, want := len(res["operations"].([]any)), 2; got != want {
+			t.Errorf("got %v\nwant %v", got, want)
+		}
+		res = call(t, "google.longrunning.Operations", "ListOperations", Message{"page_token": res["next_page_token"]})
+		if got, want := len(res["operations"].([]any)), 3; got != want {
+			t.Errorf("got %v\nwant %v", got, want)
+		}
+	})
+
+	t.Run("Reset", func(t *testing.T) {
+		if err := ts.reset(); err != nil {
+			t.Fatal(err)
+		}
+		res := call(t, "google.longrunning.Operations", "ListOperations", Message{})
+		if got, want := len(res["operations"].([]any)), 0; got != want {
+			t.Errorf("got %v\nwant %v", got, want)
+		}
+	})
+}
+
+func TestResponseOperationInvalidOption(t *testing.T) {
+	ts := NewServer(t, "testdata/lro/exporter.proto", ImportPath("testdata/lro", "proto"), EnableOperations())
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	tests := []struct {
+		name string
+		opt  OperationOption
+	}{
+		{"negative polls", DoneAfterPolls(-1)},
+		{"negative duration", DoneAfter(-time.Second)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tb := &fatalTB{TB: t}
+			m := ts.Method("ExportBooks")
+			m.t = tb
+			m.ResponseOperation(map[string]any{}, tt.opt)
+			if len(tb.fatals) == 0 {
+				t.Error("want fatal")
+			}
+		})
+	}
+}
+
+func TestResponseOperationUnknownType(t *testing.T) {
+	ts := NewServer(t, "testdata/lro/exporter.proto", ImportPath("testdata/lro", "proto"), EnableOperations())
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	ts.Method("ImportBooks").ResponseOperation(map[string]any{"count": 3})
+	if _, err := invoke(ts, "exporter.Exporter", "ImportBooks", Message{}); status.Code(err) != codes.Internal {
+		t.Errorf("got %v\nwant %v", status.Code(err), codes.Internal)
+	}
+}
+
+func TestEnableOperationsEmbedded(t *testing.T) {
+	ts := NewServer(t, "testdata/route_guide.proto", EnableOperations())
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	sd, err := ts.operationsServiceDescriptor()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := sd.ParentFile().Path(), "google/longrunning/operations.proto"; got != want {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+	md := sd.Methods().ByName("GetOperation")
+	req := dynamicpb.NewMessage(md.Input())
+	if err := UnmarshalProtoMessage(Message{"name": "operations/unknown"}, req); err != nil {
+		t.Fatal(err)
+	}
+	res := dynamicpb.NewMessage(md.Output())
+	if err := ts.Conn().Invoke(context.Background(), "/google.longrunning.Operations/GetOperation", req, res); status.Code(err) != codes.NotFound {
+		t.Errorf("got %v\nwant %v", status.Code(err), codes.NotFound)
+	}
+}
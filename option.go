@@ -1,24 +1,47 @@
 package grpcstub
 
 import (
+	"crypto/tls"
+	"fmt"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/bmatcuk/doublestar/v4"
+	"google.golang.org/grpc"
 )
 
 type config struct {
 	protos            []string
 	importPaths       []string
 	useTLS            bool
+	autoTLS           bool
 	cacert, cert, key []byte
+	clientCA          []byte
+	clientAuth        tls.ClientAuthType
+	clientCert        []byte
+	clientKey         []byte
 	healthCheck       bool
 	disableReflection bool
 	bufDirs           []string
 	bufLocks          []string
 	bufConfigs        []string
 	bufModules        []string
+	delay             delay
+	proxyTarget       string
+	proxyDialOpts     []grpc.DialOption
+	recordPath        string
+	replayPaths       []string
+	stubPaths         []string
+	network           string
+	addr              string
+	onRequest         func(req *Request)
+	admin             bool
+	connect           bool
+	httpGateway       bool
+	operations        bool
+	failOnUnmatched   bool
 }
 
 type Option func(*config) error
@@ -55,6 +78,47 @@ func UseTLS(cacert, cert, key []byte) Option {
 	}
 }
 
+// AutoTLS enable TLS using the ephemeral CA and the certificate for localhost generated at startup.
+// The CA certificate is available by Server.CACert(). It cannot be used with UseTLS.
+func AutoTLS() Option {
+	return func(c *config) error {
+		c.useTLS = true
+		c.autoTLS = true
+		return nil
+	}
+}
+
+// MTLS enable mutual TLS. It requires UseTLS or AutoTLS.
+// The server requires and verifies client certificates signed by clientCA unless ClientAuth is specified.
+// With AutoTLS, nil clientCA means the generated CA.
+func MTLS(clientCA []byte) Option {
+	return func(c *config) error {
+		c.clientCA = clientCA
+		if c.clientAuth == tls.NoClientCert {
+			c.clientAuth = tls.RequireAndVerifyClientCert
+		}
+		return nil
+	}
+}
+
+// ClientAuth set the policy the server will follow for TLS client authentication.
+func ClientAuth(mode tls.ClientAuthType) Option {
+	return func(c *config) error {
+		c.clientAuth = mode
+		return nil
+	}
+}
+
+// ClientCertificate set the client certificate which Conn() presents.
+// It is required when the server requires client certificates, except with AutoTLS which generates the client certificate.
+func ClientCertificate(cert, key []byte) Option {
+	return func(c *config) error {
+		c.clientCert = cert
+		c.clientKey = key
+		return nil
+	}
+}
+
 // EnableHealthCheck enable grpc.health.v1
 func EnableHealthCheck() Option {
 	return func(c *config) error {
@@ -71,6 +135,132 @@ func DisableReflection() Option {
 	}
 }
 
+// EnableConnect enable the Connect protocol and gRPC-Web in addition to the gRPC protocol on the same listener.
+func EnableConnect() Option {
+	return func(c *config) error {
+		c.connect = true
+		return nil
+	}
+}
+
+// EnableHTTPGateway enable HTTP/JSON transcoding of unary methods annotated with google.api.http on the same listener.
+func EnableHTTPGateway() Option {
+	return func(c *config) error {
+		c.httpGateway = true
+		return nil
+	}
+}
+
+// EnableOperations enable google.longrunning.Operations service to poll the operations returned by ResponseOperation.
+func EnableOperations() Option {
+	return func(c *config) error {
+		c.operations = true
+		return nil
+	}
+}
+
+// EnableAdmin enable grpcstub.admin.Admin service to control the running server.
+func EnableAdmin() Option {
+	return func(c *config) error {
+		c.admin = true
+		return nil
+	}
+}
+
+// FailOnUnmatchedRequests fail the test at the cleanup when there are unmatched requests, even if no expectation is set.
+func FailOnUnmatchedRequests() Option {
+	return func(c *config) error {
+		c.failOnUnmatched = true
+		return nil
+	}
+}
+
+// Delay set the delay before returning every response.
+func Delay(d time.Duration) Option {
+	return DelayRange(d, d)
+}
+
+// DelayRange set the random delay between min and max before returning every response.
+func DelayRange(min, max time.Duration) Option {
+	return func(c *config) error {
+		if min < 0 || max < min {
+			return fmt.Errorf("invalid delay range: %s-%s", min, max)
+		}
+		c.delay = delay{min: min, max: max}
+		return nil
+	}
+}
+
+// Proxy forward requests not matched by any matcher to the upstream gRPC server.
+// When opts are not specified, the connection to the upstream is insecure.
+func Proxy(target string, opts ...grpc.DialOption) Option {
+	return func(c *config) error {
+		c.proxyTarget = target
+		c.proxyDialOpts = opts
+		return nil
+	}
+}
+
+// Record record pairs of request and response forwarded by Proxy to the file.
+func Record(path string) Option {
+	return func(c *config) error {
+		c.recordPath = path
+		return nil
+	}
+}
+
+// Replay register matchers which return responses recorded by Record.
+func Replay(paths ...string) Option {
+	return func(c *config) error {
+		c.replayPaths = unique(append(c.replayPaths, paths...))
+		return nil
+	}
+}
+
+// StubFile register matchers from stub definition files (YAML or JSON) or directories.
+func StubFile(paths ...string) Option {
+	return func(c *config) error {
+		c.stubPaths = unique(append(c.stubPaths, paths...))
+		return nil
+	}
+}
+
+// Addr set the TCP address to listen on (default: 127.0.0.1:0).
+func Addr(addr string) Option {
+	return func(c *config) error {
+		c.network = "tcp"
+		c.addr = addr
+		return nil
+	}
+}
+
+// UnixSocket listen on the Unix domain socket.
+func UnixSocket(path string) Option {
+	return func(c *config) error {
+		c.network = "unix"
+		c.addr = path
+		return nil
+	}
+}
+
+// InMemory listen on the in-memory listener (bufconn) instead of the network.
+// Conn() dials through the in-memory listener.
+func InMemory() Option {
+	return func(c *config) error {
+		c.network = networkInMemory
+		c.addr = ""
+		return nil
+	}
+}
+
+// OnRequest set the function called with every received request.
+func OnRequest(fn func(req *Request)) Option {
+	return func(c *config) error {
+		c.onRequest = fn
+		return nil
+	}
+}
+
 // BufDir use buf directory.
 func BufDir(dirs ...string) Option {
 	return func(c *config) error {
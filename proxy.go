@@ -0,0 +1,275 @@
+package grpcstub
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// record is a pair of request and response proxied to the upstream.
+type record struct {
+	Service  string         `json:"service"`
+	Method   string         `json:"method"`
+	Request  recordRequest  `json:"request"`
+	Response recordResponse `json:"response"`
+}
+
+type recordRequest struct {
+	Headers  metadata.MD `json:"headers,omitempty"`
+	Messages []Message   `json:"messages"`
+}
+
+type recordResponse struct {
+	Headers  metadata.MD   `json:"headers,omitempty"`
+	Messages []Message     `json:"messages"`
+	Trailers metadata.MD   `json:"trailers,omitempty"`
+	Status   *recordStatus `json:"status,omitempty"`
+}
+
+type recordStatus struct {
+	Code    string    `yaml:"code" json:"code"`
+	Message string    `yaml:"message,omitempty" json:"message,omitempty"`
+	Details []Message `yaml:"details,omitempty" json:"details,omitempty"`
+}
+
+// forward forwards the requests to the upstream and returns the response.
+// Bidirectional streaming is not supported because the upstream may keep the state per stream.
+func (s *Server) forward(ctx context.Context, md protoreflect.MethodDescriptor, rs []*Request) (*Response, error) {
+	if md.IsStreamingClient() && md.IsStreamingServer() {
+		return nil, status.Errorf(codes.Unimplemented, "proxying bidirectional streaming method is not supported: %s", md.FullName())
+	}
+	service, method := splitMethodFullName(md.FullName())
+	outgoing := metadata.MD{}
+	if len(rs) > 0 {
+		for k, v := range rs[0].Headers {
+			if strings.HasPrefix(k, ":") || k == "content-type" || k == "user-agent" || strings.HasPrefix(k, "grpc-") {
+				continue
+			}
+			outgoing[k] = v
+		}
+	}
+	ctx = metadata.NewOutgoingContext(ctx, outgoing)
+	desc := &grpc.StreamDesc{
+		StreamName:    string(md.Name()),
+		ServerStreams: md.IsStreamingServer(),
+		ClientStreams: md.IsStreamingClient(),
+	}
+	cs, err := s.proxy.NewStream(ctx, desc, fmt.Sprintf("/%s/%s", service, method))
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rs {
+		in := dynamicpb.NewMessage(md.Input())
+		if err := UnmarshalProtoMessage(r.Message, in); err != nil {
+			return nil, err
+		}
+		if err := cs.SendMsg(in); err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return nil, err
+		}
+	}
+	if err := cs.CloseSend(); err != nil {
+		return nil, err
+	}
+	res := NewResponse()
+	var recvErr error
+	for {
+		out := dynamicpb.NewMessage(md.Output())
+		if err := cs.RecvMsg(out); err != nil {
+			if !errors.Is(err, io.EOF) {
+				recvErr = err
+			}
+			break
+		}
+		m, err := MarshalProtoMessage(out)
+		if err != nil {
+			return nil, err
+		}
+		res.Messages = append(res.Messages, m)
+	}
+	if h, err := cs.Header(); err == nil {
+		res.Headers = h
+	}
+	res.Trailers = cs.Trailer()
+	if recvErr != nil {
+		res.Status = status.Convert(recvErr)
+		res.StatusAfterMessages = true
+	}
+	if s.recordPath != "" {
+		if err := s.record(service, method, rs, res); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+func (s *Server) record(service, method string, rs []*Request, res *Response) error {
+	r := &record{
+		Service: service,
+		Method:  method,
+		Response: recordResponse{
+			Headers:  res.Headers,
+			Messages: res.Messages,
+			Trailers: res.Trailers,
+		},
+	}
+	for _, req := range rs {
+		if r.Request.Headers == nil {
+			r.Request.Headers = req.Headers
+		}
+		r.Request.Messages = append(r.Request.Messages, req.Message)
+	}
+	if res.Status != nil {
+		rst, err := s.convertStatus(res.Status)
+		if err != nil {
+			return err
+		}
+		r.Response.Status = rst
+	}
+	s.recordMu.Lock()
+	defer s.recordMu.Unlock()
+	s.records = append(s.records, r)
+	b, err := json.MarshalIndent(s.records, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(s.recordPath, b, 0600)
+}
+
+func (s *Server) convertStatus(st *status.Status) (*recordStatus, error) {
+	rst := &recordStatus{
+		Code:    st.Code().String(),
+		Message: st.Message(),
+	}
+	for _, d := range st.Proto().GetDetails() {
+		b, err := (protojson.MarshalOptions{Resolver: s.resolver(), UseProtoNames: true}).Marshal(d)
+		if err != nil {
+			return nil, err
+		}
+		m := Message{}
+		if err := json.Unmarshal(b, &m); err != nil {
+			return nil, err
+		}
+		rst.Details = append(rst.Details, m)
+	}
+	return rst, nil
+}
+
+// replay registers matchers which return the recorded responses.
+func (s *Server) replay(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var records []*record
+	if err := json.Unmarshal(b, &records); err != nil {
+		return fmt.Errorf("failed to load records %s: %w", path, err)
+	}
+	keys := make([]string, len(records))
+	last := map[string]int{}
+	for i, r := range records {
+		kb, err := json.Marshal([]any{r.Service, r.Method, r.Request.Messages})
+		if err != nil {
+			return err
+		}
+		keys[i] = string(kb)
+		last[keys[i]] = i
+	}
+	for i, r := range records {
+		var st *status.Status
+		if r.Response.Status != nil {
+			c, err := parseCode(r.Response.Status.Code)
+			if err != nil {
+				return err
+			}
+			var details []any
+			for _, d := range r.Response.Status.Details {
+				details = append(details, d)
+			}
+			st, err = s.statusWithDetails(c, r.Response.Status.Message, details...)
+			if err != nil {
+				return err
+			}
+		}
+		var wants []any
+		for _, m := range r.Request.Messages {
+			w, err := normalizeValue(m)
+			if err != nil {
+				return err
+			}
+			wants = append(wants, w)
+		}
+		m := s.Service(r.Service).Method(r.Method).Match(func(req *Request) bool {
+			for _, w := range wants {
+				if matchValue(map[string]any(req.Message), w, req.messageDescriptor(), nil) {
+					return true
+				}
+			}
+			return false
+		})
+		m.handler = func(_ *Request, _ protoreflect.MethodDescriptor) *Response {
+			// The response is built for every call because the following handlers may modify it.
+			res := NewResponse()
+			res.Headers = r.Response.Headers.Copy()
+			res.Trailers = r.Response.Trailers.Copy()
+			for _, rm := range r.Response.Messages {
+				c, err := copyMessage(rm)
+				if err != nil {
+					res.Status = status.New(codes.Internal, err.Error())
+					return res
+				}
+				res.Messages = append(res.Messages, c)
+			}
+			if st != nil {
+				res.Status = st
+				res.StatusAfterMessages = true
+			}
+			return res
+		}
+		// Identical requests recorded more than once are replayed in order.
+		if last[keys[i]] != i {
+			m.Once()
+		}
+	}
+	return nil
+}
+
+// parseCode parses code name (e.g. "NotFound", "NOT_FOUND") or number.
+func parseCode(s string) (codes.Code, error) {
+	if n, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return codes.Code(n), nil
+	}
+	for c := codes.OK; c <= codes.Unauthenticated; c++ {
+		if strings.EqualFold(c.String(), strings.ReplaceAll(s, "_", "")) {
+			return c, nil
+		}
+	}
+	var c codes.Code
+	if err := c.UnmarshalJSON([]byte(strconv.Quote(s))); err != nil {
+		return 0, fmt.Errorf("invalid status code: %s", s)
+	}
+	return c, nil
+}
+
+func dialProxy(target string, opts []grpc.DialOption) (*grpc.ClientConn, error) {
+	if len(opts) == 0 {
+		opts = append(opts, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	}
+	return grpc.NewClient(target, opts...)
+}
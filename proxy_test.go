@@ -0,0 +1,205 @@
+package grpcstub
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/k1LoW/grpcstub/testdata/routeguide"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestProxyRecordReplay(t *testing.T) {
+	ctx := context.Background()
+	record := filepath.Join(t.TempDir(), "records.json")
+	upstream := NewServer(t, "testdata/route_guide.proto")
+	t.Cleanup(func() {
+		upstream.Close()
+	})
+	upstream.Method("GetFeature").MatchMessage(map[string]any{"latitude": 10}).Header("session", "xxx").Response(map[string]any{"name": "hello"})
+	upstream.Method("GetFeature").StatusWithDetails(codes.NotFound, "not found", &errdetails.ResourceInfo{ResourceName: "feature"})
+	upstream.Method("ListFeatures").Response(map[string]any{"name": "hello"}).Response(map[string]any{"name": "world"})
+	upstream.Method("RecordRoute").Response(map[string]any{"point_count": 2})
+
+	call := func(t *testing.T, ts *Server) {
+		t.Helper()
+		client := routeguide.NewRouteGuideClient(ts.Conn())
+		{
+			var header metadata.MD
+			ctx := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer xxx")
+			res, err := client.GetFeature(ctx, &routeguide.Point{Latitude: 10}, grpc.Header(&header))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := "hello"; res.Name != want {
+				t.Errorf("got %v\nwant %v", res.Name, want)
+			}
+			if diff := cmp.Diff(header.Get("session"), []string{"xxx"}); diff != "" {
+				t.Error(diff)
+			}
+		}
+		{
+			_, err := client.GetFeature(ctx, &routeguide.Point{Latitude: 20})
+			s := status.Convert(err)
+			if s.Code() != codes.NotFound {
+				t.Errorf("got %v\nwant %v", s.Code(), codes.NotFound)
+			}
+			if len(s.Details()) != 1 {
+				t.Errorf("got %v\nwant %v", len(s.Details()), 1)
+			}
+		}
+		{
+			stream, err := client.ListFeatures(ctx, &routeguide.Rectangle{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for {
+				res, err := stream.Recv()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, res.Name)
+			}
+			if diff := cmp.Diff(got, []string{"hello", "world"}); diff != "" {
+				t.Error(diff)
+			}
+		}
+		{
+			stream, err := client.RecordRoute(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := range 2 {
+				if err := stream.Send(&routeguide.Point{Latitude: int32(i)}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			res, err := stream.CloseAndRecv()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := int32(2); res.PointCount != want {
+				t.Errorf("got %v\nwant %v", res.PointCount, want)
+			}
+		}
+	}
+
+	t.Run("Proxy and record", func(t *testing.T) {
+		ts := NewServer(t, "testdata/route_guide.proto", Proxy(upstream.Addr()), Record(record))
+		t.Cleanup(func() {
+			ts.Close()
+		})
+		call(t, ts)
+		if got, want := len(upstream.Requests()), 5; got != want {
+			t.Errorf("got %v\nwant %v", got, want)
+		}
+		r := upstream.Requests()[0]
+		if diff := cmp.Diff(r.Headers.Get("authorization"), []string{"Bearer xxx"}); diff != "" {
+			t.Error(diff)
+		}
+	})
+
+	t.Run("Replay", func(t *testing.T) {
+		upstream.ClearRequests()
+		ts := NewServer(t, "testdata/route_guide.proto", Replay(record))
+		t.Cleanup(func() {
+			ts.Close()
+		})
+		call(t, ts)
+		if got, want := len(upstream.Requests()), 0; got != want {
+			t.Errorf("got %v\nwant %v", got, want)
+		}
+	})
+}
+
+func TestParseCode(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    codes.Code
+		wantErr bool
+	}{
+		{"NotFound", codes.NotFound, false},
+		{"NOT_FOUND", codes.NotFound, false},
+		{"CANCELLED", codes.Canceled, false},
+		{"5", codes.NotFound, false},
+		{"Unknown code", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := parseCode(tt.in)
+			if err != nil {
+				if !tt.wantErr {
+					t.Errorf("got error: %v", err)
+				}
+				return
+			}
+			if tt.wantErr {
+				t.Error("want error")
+			}
+			if got != tt.want {
+				t.Errorf("got %v\nwant %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxyBidiStreaming(t *testing.T) {
+	upstream := NewServer(t, "testdata/route_guide.proto")
+	t.Cleanup(func() {
+		upstream.Close()
+	})
+	upstream.Method("RouteChat").Response(map[string]any{"message": "hello"})
+	ts := NewServer(t, "testdata/route_guide.proto", Proxy(upstream.Addr()))
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	client := routeguide.NewRouteGuideClient(ts.Conn())
+	stream, err := client.RouteChat(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Send(&routeguide.RouteNote{Message: "hi"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.Unimplemented {
+		t.Errorf("got %v\nwant %v", status.Code(err), codes.Unimplemented)
+	}
+	if got, want := len(upstream.Requests()), 0; got != want {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+}
+
+func TestReplayResponsePerCall(t *testing.T) {
+	record := filepath.Join(t.TempDir(), "records.json")
+	in := `[{"service": "routeguide.RouteGuide", "method": "GetFeature", "request": {"messages": [{"latitude": 10}]}, "response": {"headers": {"session": ["xxx"]}, "messages": [{"name": "hello"}]}}]`
+	if err := os.WriteFile(record, []byte(in), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ts := NewServer(t, "testdata/route_guide.proto", Replay(record))
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	m := ts.matchers[0]
+	first := m.handler(nil, nil)
+	first.Messages[0]["name"] = "modified"
+	first.Headers.Set("session", "modified")
+	second := m.handler(nil, nil)
+	if got, want := second.Messages[0]["name"], "hello"; got != want {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+	if diff := cmp.Diff(second.Headers.Get("session"), []string{"xxx"}); diff != "" {
+		t.Error(diff)
+	}
+}
@@ -0,0 +1,67 @@
+package grpcstub
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/bufbuild/protocompile/linker"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+var _ interface {
+	protoregistry.MessageTypeResolver
+	protoregistry.ExtensionTypeResolver
+} = (*resolver)(nil)
+
+// resolver resolves types from the registered Go types and the compiled descriptors.
+type resolver struct {
+	fds linker.Files
+}
+
+func (s *Server) resolver() *resolver {
+	return &resolver{fds: s.fds}
+}
+
+func (r *resolver) FindMessageByName(name protoreflect.FullName) (protoreflect.MessageType, error) {
+	if mt, err := protoregistry.GlobalTypes.FindMessageByName(name); err == nil {
+		return mt, nil
+	}
+	if mt, err := r.fds.AsResolver().FindMessageByName(name); err == nil {
+		return mt, nil
+	}
+	d, err := protoregistry.GlobalFiles.FindDescriptorByName(name)
+	if err != nil {
+		return nil, err
+	}
+	md, ok := d.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, protoregistry.NotFound
+	}
+	return dynamicpb.NewMessageType(md), nil
+}
+
+func (r *resolver) FindMessageByURL(url string) (protoreflect.MessageType, error) {
+	name := url
+	if i := strings.LastIndexByte(url, '/'); i >= 0 {
+		name = url[i+1:]
+	}
+	return r.FindMessageByName(protoreflect.FullName(name))
+}
+
+func (r *resolver) FindExtensionByName(field protoreflect.FullName) (protoreflect.ExtensionType, error) {
+	xt, err := protoregistry.GlobalTypes.FindExtensionByName(field)
+	if err == nil || !errors.Is(err, protoregistry.NotFound) {
+		return xt, err
+	}
+	return r.fds.AsResolver().FindExtensionByName(field)
+}
+
+func (r *resolver) FindExtensionByNumber(message protoreflect.FullName, field protoreflect.FieldNumber) (protoreflect.ExtensionType, error) {
+	xt, err := protoregistry.GlobalTypes.FindExtensionByNumber(message, field)
+	if err == nil || !errors.Is(err, protoregistry.NotFound) {
+		return xt, err
+	}
+	return r.fds.AsResolver().FindExtensionByNumber(message, field)
+}
@@ -0,0 +1,92 @@
+package grpcstub
+
+// ScenarioStarted is the initial state of scenarios.
+const ScenarioStarted = "Started"
+
+type scenario struct {
+	name string
+	s    *Server
+}
+
+// Scenario returns the scenario which is the state machine shared by matchers across calls.
+func (s *Server) Scenario(name string) *scenario {
+	return &scenario{name: name, s: s}
+}
+
+// InState create request matcher which matches when the scenario is in state.
+func (sc *scenario) InState(state string) *matcher {
+	return sc.s.newMatcher(func(_ *Request) bool { return true }).InScenario(sc.name).InState(state)
+}
+
+// State returns the current state of the scenario.
+func (sc *scenario) State() string {
+	sc.s.scenarioMu.Lock()
+	defer sc.s.scenarioMu.Unlock()
+	return sc.s.scenarioState(sc.name)
+}
+
+// SetState set the state of the scenario.
+func (sc *scenario) SetState(state string) {
+	sc.s.scenarioMu.Lock()
+	defer sc.s.scenarioMu.Unlock()
+	if sc.s.scenarios == nil {
+		sc.s.scenarios = map[string]string{}
+	}
+	sc.s.scenarios[sc.name] = state
+}
+
+// Reset set the state of the scenario to ScenarioStarted.
+func (sc *scenario) Reset() {
+	sc.SetState(ScenarioStarted)
+}
+
+// InScenario set the scenario of the matcher used by InState and SetState.
+func (m *matcher) InScenario(name string) *matcher {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.scenario = name
+	return m
+}
+
+// InState append request matcher which matches when the scenario is in state.
+func (m *matcher) InState(state string) *matcher {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.inState = state
+	return m
+}
+
+// SetState set the state to which the scenario transitions when the matcher matches.
+func (m *matcher) SetState(state string) *matcher {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.newState = state
+	return m
+}
+
+// transitScenario checks that the scenario is in the state from, and transitions it to the state to.
+// Empty from matches any state, and empty to keeps the state.
+func (s *Server) transitScenario(name, from, to string) bool {
+	if from == "" && to == "" {
+		return true
+	}
+	s.scenarioMu.Lock()
+	defer s.scenarioMu.Unlock()
+	if from != "" && s.scenarioState(name) != from {
+		return false
+	}
+	if to != "" {
+		if s.scenarios == nil {
+			s.scenarios = map[string]string{}
+		}
+		s.scenarios[name] = to
+	}
+	return true
+}
+
+func (s *Server) scenarioState(name string) string {
+	if st, ok := s.scenarios[name]; ok {
+		return st
+	}
+	return ScenarioStarted
+}
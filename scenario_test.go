@@ -0,0 +1,91 @@
+package grpcstub
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/k1LoW/grpcstub/testdata/routeguide"
+)
+
+func TestScenario(t *testing.T) {
+	ctx := context.Background()
+	ts := NewServer(t, "testdata/route_guide.proto")
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	sc := ts.Scenario("feature")
+	sc.InState(ScenarioStarted).Method("GetFeature").SetState("created").Response(map[string]any{"name": "first"})
+	sc.InState("created").Method("GetFeature").SetState("done").Response(map[string]any{"name": "second"})
+	ts.Method("GetFeature").InScenario("feature").InState("done").Response(map[string]any{"name": "done"})
+	ts.Method("GetFeature").Response(map[string]any{"name": "default"})
+	client := routeguide.NewRouteGuideClient(ts.Conn())
+
+	call := func(t *testing.T) string {
+		t.Helper()
+		res, err := client.GetFeature(ctx, &routeguide.Point{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.Name
+	}
+	if got, want := sc.State(), ScenarioStarted; got != want {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+	for _, want := range []string{"first", "second", "done", "done"} {
+		if got := call(t); got != want {
+			t.Errorf("got %v\nwant %v", got, want)
+		}
+	}
+	if got, want := sc.State(), "done"; got != want {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+
+	sc.SetState("unknown")
+	if got, want := call(t), "default"; got != want {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+
+	sc.Reset()
+	if got, want := call(t), "first"; got != want {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+}
+
+func TestScenarioStubFile(t *testing.T) {
+	ctx := context.Background()
+	p := filepath.Join(t.TempDir(), "stubs.yaml")
+	in := `stubs:
+  - method: GetFeature
+    scenario: polling
+    inState: Started
+    setState: done
+    response:
+      messages:
+        - name: pending
+  - method: GetFeature
+    scenario: polling
+    inState: done
+    response:
+      messages:
+        - name: done
+`
+	if err := os.WriteFile(p, []byte(in), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ts := NewServer(t, "testdata/route_guide.proto", StubFile(p))
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	client := routeguide.NewRouteGuideClient(ts.Conn())
+	for _, want := range []string{"pending", "done", "done"} {
+		res, err := client.GetFeature(ctx, &routeguide.Point{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := res.Name; got != want {
+			t.Errorf("got %v\nwant %v", got, want)
+		}
+	}
+}
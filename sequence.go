@@ -0,0 +1,42 @@
+package grpcstub
+
+// SequenceMode is the behavior of the matcher after all responses of the sequence are returned.
+type SequenceMode int
+
+const (
+	// SequenceRepeatLast returns the last response repeatedly (default).
+	SequenceRepeatLast SequenceMode = iota
+	// SequenceCycle returns the responses from the first again.
+	SequenceCycle
+	// SequenceFallThrough stops matching so that the request falls through to the next matcher.
+	SequenceFallThrough
+)
+
+// Then starts the next response of the sequence.
+// The Nth matching call returns the Nth response.
+func (m *matcher) Then() *matcher {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.handlers = append(m.handlers, m.handler)
+	m.handler = nil
+	return m
+}
+
+// ResponseSequence set handlers which return messages in order on successive calls.
+func (m *matcher) ResponseSequence(messages ...any) *matcher {
+	for i, message := range messages {
+		if i > 0 {
+			m.Then()
+		}
+		m.Response(message)
+	}
+	return m
+}
+
+// OnSequenceExhausted set the behavior of the matcher after all responses of the sequence are returned.
+func (m *matcher) OnSequenceExhausted(mode SequenceMode) *matcher {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sequenceMode = mode
+	return m
+}
@@ -0,0 +1,75 @@
+package grpcstub
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/k1LoW/grpcstub/testdata/routeguide"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestResponseSequence(t *testing.T) {
+	tests := []struct {
+		mode SequenceMode
+		want []string
+	}{
+		{SequenceRepeatLast, []string{"one", "two", "three", "three", "three"}},
+		{SequenceCycle, []string{"one", "two", "three", "one", "two"}},
+		{SequenceFallThrough, []string{"one", "two", "three", "default", "default"}},
+	}
+	ctx := context.Background()
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%d", tt.mode), func(t *testing.T) {
+			ts := NewServer(t, "testdata/route_guide.proto")
+			t.Cleanup(func() {
+				ts.Close()
+			})
+			ts.Method("GetFeature").ResponseSequence(
+				map[string]any{"name": "one"},
+				map[string]any{"name": "two"},
+				map[string]any{"name": "three"},
+			).OnSequenceExhausted(tt.mode)
+			ts.Method("GetFeature").Response(map[string]any{"name": "default"})
+
+			client := routeguide.NewRouteGuideClient(ts.Conn())
+			var got []string
+			for range tt.want {
+				res, err := client.GetFeature(ctx, &routeguide.Point{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, res.Name)
+			}
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestThen(t *testing.T) {
+	ctx := context.Background()
+	ts := NewServer(t, "testdata/route_guide.proto")
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	ts.Method("GetFeature").
+		Status(status.New(codes.Unavailable, "unavailable")).
+		Then().
+		Header("attempt", "2").Response(map[string]any{"name": "hello"})
+
+	client := routeguide.NewRouteGuideClient(ts.Conn())
+	if _, err := client.GetFeature(ctx, &routeguide.Point{}); status.Code(err) != codes.Unavailable {
+		t.Errorf("got %v\nwant %v", status.Code(err), codes.Unavailable)
+	}
+	res, err := client.GetFeature(ctx, &routeguide.Point{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "hello"; res.Name != want {
+		t.Errorf("got %v\nwant %v", res.Name, want)
+	}
+}
@@ -0,0 +1,406 @@
+package grpcstub
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// statefulStore is the in-memory store of the resources for the standard methods of AIP (https://google.aip.dev/121).
+type statefulStore struct {
+	resources map[string][]*storedResource // resource type name -> resources in insertion order
+	mu        sync.Mutex
+}
+
+type storedResource struct {
+	key     string
+	parent  string
+	message Message
+}
+
+// ResponseStateful set handler which stores the resources in memory and responds to the standard methods of AIP.
+// Create<Resource>, Get<Resource>, List<Resources>, Update<Resource> and Delete<Resource> are inferred from the method names and the message descriptors.
+// Resources are keyed by the name field (or the id field), and the name is generated as "{parent}/{collection}/{id}" when it is empty.
+func (m *matcher) ResponseStateful() *matcher {
+	st := &statefulStore{resources: map[string][]*storedResource{}}
+	prev := m.handler
+	m.handler = func(req *Request, md protoreflect.MethodDescriptor) *Response {
+		var res *Response
+		if prev == nil {
+			res = NewResponse()
+		} else {
+			res = prev(req, md)
+		}
+		mm, err := st.handle(req, md)
+		if err != nil {
+			res.Status = status.Convert(err)
+			return res
+		}
+		res.Messages = append(res.Messages, mm)
+		return res
+	}
+	return m
+}
+
+// ResponseStateful set handler which stores the resources in memory and responds to the standard methods of AIP.
+func (s *Server) ResponseStateful() *matcher {
+	m := &matcher{
+		matchFuncs: []matchFunc{func(_ *Request) bool { return true }},
+		s:          s,
+		t:          s.t,
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addMatcher(m)
+	return m.ResponseStateful()
+}
+
+func (st *statefulStore) handle(req *Request, md protoreflect.MethodDescriptor) (Message, error) {
+	if md.IsStreamingClient() || md.IsStreamingServer() {
+		return nil, status.Errorf(codes.Unimplemented, "%s is not a standard method", md.FullName())
+	}
+	name := string(md.Name())
+	switch {
+	case strings.HasPrefix(name, "Create"):
+		return st.create(req.Message, md)
+	case strings.HasPrefix(name, "Get"):
+		return st.get(req.Message, md, strings.TrimPrefix(name, "Get"))
+	case strings.HasPrefix(name, "List"):
+		return st.list(req.Message, md)
+	case strings.HasPrefix(name, "Update"):
+		return st.update(req.Message, md)
+	case strings.HasPrefix(name, "Delete"):
+		return st.delete(req.Message, md, strings.TrimPrefix(name, "Delete"))
+	}
+	return nil, status.Errorf(codes.Unimplemented, "%s is not a standard method", md.FullName())
+}
+
+func (st *statefulStore) create(in Message, md protoreflect.MethodDescriptor) (Message, error) {
+	rd := md.Output()
+	resource, err := resourceFromRequest(in, md)
+	if err != nil {
+		return nil, err
+	}
+	kf := resourceKeyField(rd)
+	if kf == "" {
+		return nil, status.Errorf(codes.Unimplemented, "%s has no name or id field", rd.FullName())
+	}
+	key, _ := resource[kf].(string)
+	if key == "" {
+		id, _ := in[toSnakeCase(string(rd.Name()))+"_id"].(string)
+		if id == "" {
+			id, err = newUUID()
+			if err != nil {
+				return nil, err
+			}
+		}
+		key = id
+		if kf == "name" {
+			key = collectionID(string(rd.Name())) + "/" + id
+			if parent, _ := in["parent"].(string); parent != "" {
+				key = parent + "/" + key
+			}
+		}
+		resource[kf] = key
+	}
+	parent, _ := in["parent"].(string)
+	if parent == "" && kf == "name" {
+		// {parent}/{collection}/{id}
+		if segs := strings.Split(key, "/"); len(segs) > 2 {
+			parent = strings.Join(segs[:len(segs)-2], "/")
+		}
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	t := string(rd.Name())
+	if st.find(t, key) >= 0 {
+		return nil, status.Errorf(codes.AlreadyExists, "%s already exists", key)
+	}
+	st.resources[t] = append(st.resources[t], &storedResource{key: key, parent: parent, message: resource})
+	return copyMessage(resource)
+}
+
+func (st *statefulStore) get(in Message, md protoreflect.MethodDescriptor, noun string) (Message, error) {
+	key, err := requestKey(in, md.Input(), noun)
+	if err != nil {
+		return nil, err
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	t := string(md.Output().Name())
+	i := st.find(t, key)
+	if i < 0 {
+		return nil, status.Errorf(codes.NotFound, "%s not found", key)
+	}
+	return copyMessage(st.resources[t][i].message)
+}
+
+func (st *statefulStore) list(in Message, md protoreflect.MethodDescriptor) (Message, error) {
+	var fd protoreflect.FieldDescriptor
+	fields := md.Output().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		f := fields.Get(i)
+		if f.IsList() && f.Message() != nil {
+			fd = f
+			break
+		}
+	}
+	if fd == nil {
+		return nil, status.Errorf(codes.Unimplemented, "%s has no repeated resource field", md.Output().FullName())
+	}
+	parent, _ := in["parent"].(string)
+	pageSize := 0
+	if v, ok := in["page_size"].(float64); ok {
+		pageSize = int(v)
+	}
+	if pageSize < 0 {
+		return nil, status.Error(codes.InvalidArgument, "page_size must not be negative")
+	}
+	offset := 0
+	if token, _ := in["page_token"].(string); token != "" {
+		v, err := strconv.Atoi(token)
+		if err != nil || v < 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid page_token: %s", token)
+		}
+		offset = v
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	var items []Message
+	for _, r := range st.resources[string(fd.Message().Name())] {
+		if parent != "" && r.parent != parent {
+			continue
+		}
+		items = append(items, r.message)
+	}
+	offset = min(offset, len(items))
+	end := len(items)
+	if pageSize > 0 {
+		end = min(offset+pageSize, len(items))
+	}
+	resources := []any{}
+	for _, item := range items[offset:end] {
+		c, err := copyMessage(item)
+		if err != nil {
+			return nil, err
+		}
+		resources = append(resources, map[string]any(c))
+	}
+	out := Message{string(fd.Name()): resources}
+	if end < len(items) && fields.ByName("next_page_token") != nil {
+		out["next_page_token"] = strconv.Itoa(end)
+	}
+	return out, nil
+}
+
+func (st *statefulStore) update(in Message, md protoreflect.MethodDescriptor) (Message, error) {
+	rd := md.Output()
+	resource, err := resourceFromRequest(in, md)
+	if err != nil {
+		return nil, err
+	}
+	kf := resourceKeyField(rd)
+	if kf == "" {
+		return nil, status.Errorf(codes.Unimplemented, "%s has no name or id field", rd.FullName())
+	}
+	key, _ := resource[kf].(string)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	t := string(rd.Name())
+	i := st.find(t, key)
+	if i < 0 {
+		return nil, status.Errorf(codes.NotFound, "%s not found", key)
+	}
+	stored := st.resources[t][i]
+	mask, _ := in["update_mask"].(string)
+	if mask == "" || mask == "*" {
+		stored.message = resource
+		return copyMessage(stored.message)
+	}
+	var paths [][]string
+	for _, p := range strings.Split(mask, ",") {
+		var path []string
+		for _, f := range strings.Split(p, ".") {
+			path = append(path, toSnakeCase(f))
+		}
+		if !hasFieldPath(rd, path) {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid update_mask: %s is not a field of %s", p, rd.FullName())
+		}
+		paths = append(paths, path)
+	}
+	for _, path := range paths {
+		if path[0] == kf {
+			continue
+		}
+		setPath(stored.message, path, getPath(resource, path))
+	}
+	return copyMessage(stored.message)
+}
+
+func (st *statefulStore) delete(in Message, md protoreflect.MethodDescriptor, noun string) (Message, error) {
+	key, err := requestKey(in, md.Input(), noun)
+	if err != nil {
+		return nil, err
+	}
+	// The resource message is the output of Delete<Resource>, or <Resource> when the output is google.protobuf.Empty or the like.
+	t := noun
+	if resourceKeyField(md.Output()) != "" {
+		t = string(md.Output().Name())
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	i := st.find(t, key)
+	if i < 0 {
+		return nil, status.Errorf(codes.NotFound, "%s not found", key)
+	}
+	deleted := st.resources[t][i]
+	st.resources[t] = append(st.resources[t][:i], st.resources[t][i+1:]...)
+	if string(md.Output().Name()) == t {
+		return deleted.message, nil
+	}
+	return Message{}, nil
+}
+
+func (st *statefulStore) find(t, key string) int {
+	for i, r := range st.resources[t] {
+		if r.key == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// resourceFromRequest returns the copy of the resource in the request of Create or Update method.
+func resourceFromRequest(in Message, md protoreflect.MethodDescriptor) (Message, error) {
+	rd := md.Output()
+	if md.Input().FullName() == rd.FullName() {
+		return copyMessage(in)
+	}
+	fields := md.Input().Fields()
+	for i := 0; i < fields.Len(); i++ {
+		f := fields.Get(i)
+		if f.IsList() || f.IsMap() || f.Message() == nil || f.Message().FullName() != rd.FullName() {
+			continue
+		}
+		v, _ := in[string(f.Name())].(map[string]any)
+		if v == nil {
+			return Message{}, nil
+		}
+		return copyMessage(v)
+	}
+	return nil, status.Errorf(codes.Unimplemented, "%s has no %s field", md.Input().FullName(), rd.FullName())
+}
+
+// resourceKeyField returns the name of the string field identifying the resource.
+func resourceKeyField(rd protoreflect.MessageDescriptor) string {
+	for _, n := range []protoreflect.Name{"name", "id"} {
+		if f := rd.Fields().ByName(n); f != nil && f.Kind() == protoreflect.StringKind && !f.IsList() {
+			return string(n)
+		}
+	}
+	return ""
+}
+
+// requestKey returns the key of the resource in the request of Get or Delete method.
+func requestKey(in Message, id protoreflect.MessageDescriptor, noun string) (string, error) {
+	for _, n := range []string{"name", toSnakeCase(noun) + "_id", "id"} {
+		if f := id.Fields().ByName(protoreflect.Name(n)); f != nil && f.Kind() == protoreflect.StringKind {
+			key, _ := in[n].(string)
+			return key, nil
+		}
+	}
+	return "", status.Errorf(codes.Unimplemented, "%s has no name or id field", id.FullName())
+}
+
+// hasFieldPath reports whether the path of the field names exists in the message.
+func hasFieldPath(md protoreflect.MessageDescriptor, path []string) bool {
+	for i, n := range path {
+		if md == nil {
+			return false
+		}
+		f := md.Fields().ByName(protoreflect.Name(n))
+		if f == nil {
+			return false
+		}
+		if i < len(path)-1 {
+			if f.IsList() || f.IsMap() {
+				return false
+			}
+			md = f.Message()
+		}
+	}
+	return true
+}
+
+// collectionID returns the collection identifier of the resource type (e.g. "Book" -> "books").
+func collectionID(name string) string {
+	s := []rune(name)
+	s[0] = unicode.ToLower(s[0])
+	c := string(s)
+	switch {
+	case len(c) > 1 && strings.HasSuffix(c, "y") && !strings.ContainsAny(c[len(c)-2:len(c)-1], "aeiou"):
+		return strings.TrimSuffix(c, "y") + "ies"
+	case strings.HasSuffix(c, "s"), strings.HasSuffix(c, "x"), strings.HasSuffix(c, "ch"), strings.HasSuffix(c, "sh"):
+		return c + "es"
+	}
+	return c + "s"
+}
+
+// toSnakeCase converts UpperCamelCase or lowerCamelCase to snake_case.
+func toSnakeCase(s string) string {
+	var b strings.Builder
+	for i, r := range s {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func getPath(m map[string]any, path []string) any {
+	v, ok := m[path[0]]
+	if !ok || len(path) == 1 {
+		return v
+	}
+	child, ok := v.(map[string]any)
+	if !ok {
+		return nil
+	}
+	return getPath(child, path[1:])
+}
+
+func setPath(m map[string]any, path []string, v any) {
+	if len(path) == 1 {
+		m[path[0]] = v
+		return
+	}
+	child, ok := m[path[0]].(map[string]any)
+	if !ok {
+		child = map[string]any{}
+		m[path[0]] = child
+	}
+	setPath(child, path[1:], v)
+}
+
+func copyMessage(m map[string]any) (Message, error) {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	c := Message{}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
@@ -0,0 +1,230 @@
+package grpcstub
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func TestResponseStateful(t *testing.T) {
+	ts := NewServer(t, "testdata/library/library.proto")
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	ts.Service("library.Library").ResponseStateful()
+
+	call := func(t *testing.T, method string, in Message) Message {
+		t.Helper()
+		res, err := invoke(ts, "library.Library", method, in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	shelf := call(t, "CreateShelf", Message{"shelf": map[string]any{"name": "shelves/fiction", "theme": "fiction"}})
+	if got, want := shelf["name"], "shelves/fiction"; got != want {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+	if _, err := invoke(ts, "library.Library", "CreateShelf", Message{"shelf": map[string]any{"name": "shelves/fiction"}}); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("got %v\nwant %v", status.Code(err), codes.AlreadyExists)
+	}
+
+	b1 := call(t, "CreateBook", Message{"parent": "shelves/fiction", "book_id": "dune", "book": map[string]any{"title": "Dune", "page_count": 412}})
+	if got, want := b1["name"], "shelves/fiction/books/dune"; got != want {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+	b2 := call(t, "CreateBook", Message{"parent": "shelves/fiction", "book": map[string]any{"title": "Solaris"}})
+	if got, want := fmt.Sprint(b2["name"]), "shelves/fiction/books/"; len(got) <= len(want) || got[:len(want)] != want {
+		t.Errorf("got %v\nwant %v{id}", got, want)
+	}
+	call(t, "CreateBook", Message{"parent": "shelves/history", "book_id": "spqr", "book": map[string]any{"title": "SPQR"}})
+
+	t.Run("Get", func(t *testing.T) {
+		got := call(t, "GetBook", Message{"name": "shelves/fiction/books/dune"})
+		if diff := cmp.Diff(got, b1); diff != "" {
+			t.Error(diff)
+		}
+		if _, err := invoke(ts, "library.Library", "GetBook", Message{"name": "shelves/fiction/books/unknown"}); status.Code(err) != codes.NotFound {
+			t.Errorf("got %v\nwant %v", status.Code(err), codes.NotFound)
+		}
+	})
+
+	t.Run("List", func(t *testing.T) {
+		res := call(t, "ListBooks", Message{"parent": "shelves/fiction", "page_size": 1})
+		if got, want := len(res["books"].([]any)), 1; got != want {
+			t.Fatalf("got %v\nwant %v", got, want)
+		}
+		if got, want := res["books"].([]any)[0].(map[string]any)["title"], "Dune"; got != want {
+			t.Errorf("got %v\nwant %v", got, want)
+		}
+		token := res["next_page_token"]
+		if token == "" {
+			t.Fatal("want next_page_token")
+		}
+		res = call(t, "ListBooks", Message{"parent": "shelves/fiction", "page_size": 1, "page_token": token})
+		if got, want := res["books"].([]any)[0].(map[string]any)["title"], "Solaris"; got != want {
+			t.Errorf("got %v\nwant %v", got, want)
+		}
+		if got, want := res["next_page_token"], ""; got != want {
+			t.Errorf("got %v\nwant %v", got, want)
+		}
+		res = call(t, "ListBooks", Message{})
+		if got, want := len(res["books"].([]any)), 3; got != want {
+			t.Errorf("got %v\nwant %v", got, want)
+		}
+		if _, err := invoke(ts, "library.Library", "ListBooks", Message{"page_token": "invalid"}); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("got %v\nwant %v", status.Code(err), codes.InvalidArgument)
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		got := call(t, "UpdateBook", Message{"book": map[string]any{"name": "shelves/fiction/books/dune", "title": "Dune Messiah", "author": "Frank Herbert"}, "update_mask": "author"})
+		if got, want := got["title"], "Dune"; got != want {
+			t.Errorf("got %v\nwant %v", got, want)
+		}
+		if got, want := got["author"], "Frank Herbert"; got != want {
+			t.Errorf("got %v\nwant %v", got, want)
+		}
+		got = call(t, "UpdateBook", Message{"book": map[string]any{"name": "shelves/fiction/books/dune", "title": "Dune Messiah"}})
+		if got, want := got["author"], ""; got != want {
+			t.Errorf("got %v\nwant %v", got, want)
+		}
+		got = call(t, "GetBook", Message{"name": "shelves/fiction/books/dune"})
+		if got, want := got["title"], "Dune Messiah"; got != want {
+			t.Errorf("got %v\nwant %v", got, want)
+		}
+		if _, err := invoke(ts, "library.Library", "UpdateBook", Message{"book": map[string]any{"name": "shelves/fiction/books/dune"}, "update_mask": "unknown"}); status.Code(err) != codes.InvalidArgument {
+			t.Errorf("got %v\nwant %v", status.Code(err), codes.InvalidArgument)
+		}
+		if _, err := invoke(ts, "library.Library", "UpdateBook", Message{"book": map[string]any{"name": "shelves/fiction/books/unknown"}}); status.Code(err) != codes.NotFound {
+			t.Errorf("got %v\nwant %v", status.Code(err), codes.NotFound)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		call(t, "DeleteBook", Message{"name": "shelves/fiction/books/dune"})
+		if _, err := invoke(ts, "library.Library", "GetBook", Message{"name": "shelves/fiction/books/dune"}); status.Code(err) != codes.NotFound {
+			t.Errorf("got %v\nwant %v", status.Code(err), codes.NotFound)
+		}
+		if _, err := invoke(ts, "library.Library", "DeleteBook", Message{"name": "shelves/fiction/books/dune"}); status.Code(err) != codes.NotFound {
+			t.Errorf("got %v\nwant %v", status.Code(err), codes.NotFound)
+		}
+	})
+
+	t.Run("resources keyed by id", func(t *testing.T) {
+		m := call(t, "CreateMember", Message{"parent": "libraries/main", "member": map[string]any{"display_name": "alice"}})
+		call(t, "CreateMember", Message{"parent": "libraries/branch", "member": map[string]any{"display_name": "bob"}})
+		res := call(t, "ListMembers", Message{"parent": "libraries/main"})
+		if got, want := len(res["members"].([]any)), 1; got != want {
+			t.Fatalf("got %v\nwant %v", got, want)
+		}
+		if got, want := res["members"].([]any)[0].(map[string]any)["display_name"], "alice"; got != want {
+			t.Errorf("got %v\nwant %v", got, want)
+		}
+		call(t, "DeleteMember", Message{"member_id": m["id"]})
+		res = call(t, "ListMembers", Message{})
+		if got, want := len(res["members"].([]any)), 1; got != want {
+			t.Errorf("got %v\nwant %v", got, want)
+		}
+	})
+
+	t.Run("not a standard method", func(t *testing.T) {
+		if _, err := invoke(ts, "library.Library", "MoveBook", Message{}); status.Code(err) != codes.Unimplemented {
+			t.Errorf("got %v\nwant %v", status.Code(err), codes.Unimplemented)
+		}
+	})
+}
+
+func TestStatefulUpdateMask(t *testing.T) {
+	ts := NewServer(t, "testdata/library/library.proto")
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	create, err := ts.findMethods("library.Library", "CreateBook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	update, err := ts.findMethods("library.Library", "UpdateBook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		mask     string
+		want     Message
+		wantCode codes.Code
+	}{
+		{"full replace", "*", Message{"name": "shelves/a/books/b", "title": "new"}, codes.OK},
+		{"field", "title", Message{"name": "shelves/a/books/b", "title": "new", "author": "alice"}, codes.OK},
+		{"unknown field", "title,unknown", nil, codes.InvalidArgument},
+		{"unknown nested field", "title.unknown", nil, codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := &statefulStore{resources: map[string][]*storedResource{}}
+			if _, err := st.create(Message{"book": map[string]any{"name": "shelves/a/books/b", "title": "old", "author": "alice"}}, create[0]); err != nil {
+				t.Fatal(err)
+			}
+			got, err := st.update(Message{"book": map[string]any{"name": "shelves/a/books/b", "title": "new"}, "update_mask": tt.mask}, update[0])
+			if status.Code(err) != tt.wantCode {
+				t.Fatalf("got %v\nwant %v", status.Code(err), tt.wantCode)
+			}
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Error(diff)
+			}
+		})
+	}
+}
+
+func TestCollectionID(t *testing.T) {
+	tests := []struct {
+		in   string
+		want string
+	}{
+		{"Book", "books"},
+		{"ShelfItem", "shelfItems"},
+		{"Library", "libraries"},
+		{"Key", "keys"},
+		{"Address", "addresses"},
+	}
+	for _, tt := range tests {
+		if got := collectionID(tt.in); got != tt.want {
+			t.Errorf("got %v\nwant %v", got, tt.want)
+		}
+	}
+}
+
+// invoke calls the method of the server using the dynamic messages.
+// The response is resolved by the compiled protos of the server to unpack google.protobuf.Any.
+func invoke(ts *Server, service, method string, in Message) (Message, error) {
+	mds, err := ts.findMethods(service, method)
+	if err != nil {
+		return nil, err
+	}
+	md := mds[0]
+	req := dynamicpb.NewMessage(md.Input())
+	if err := UnmarshalProtoMessage(in, req); err != nil {
+		return nil, err
+	}
+	res := dynamicpb.NewMessage(md.Output())
+	if err := ts.Conn().Invoke(context.Background(), fmt.Sprintf("/%s/%s", service, method), req, res); err != nil {
+		return nil, err
+	}
+	b, err := protojson.MarshalOptions{UseProtoNames: true, UseEnumNumbers: true, EmitUnpopulated: true, Resolver: ts.resolver()}.Marshal(res)
+	if err != nil {
+		return nil, err
+	}
+	out := Message{}
+	if err := json.Unmarshal(b, &out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
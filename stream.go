@@ -0,0 +1,121 @@
+package grpcstub
+
+import (
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+type streamScript struct {
+	m     *matcher
+	steps []streamStep
+	mu    sync.RWMutex
+}
+
+type streamStep struct {
+	message Message
+	wait    time.Duration
+	status  *status.Status
+}
+
+// Stream set handler which return response messages of the stream according to the script.
+// The messages set by the previous handlers (e.g. Response(m0).Stream()) are sent before the steps of the script.
+// The error status set by the previous handlers ends the stream before the steps, or after them with StatusAfterMessages.
+// e.g. Stream().Send(m1).Wait(100*time.Millisecond).Send(m2).Error(status.New(codes.Unavailable, "unavailable")).End().Times(1)
+func (m *matcher) Stream() *streamScript {
+	sc := &streamScript{m: m}
+	prev := m.handler
+	m.handler = func(req *Request, md protoreflect.MethodDescriptor) *Response {
+		var res *Response
+		if prev == nil {
+			res = NewResponse()
+		} else {
+			res = prev(req, md)
+		}
+		sc.mu.RLock()
+		defer sc.mu.RUnlock()
+		played := &streamScript{m: m}
+		prevStatus := res.Status
+		if prevStatus != nil && prevStatus.Err() != nil && !res.StatusAfterMessages {
+			// The status set by the previous handlers ends the stream before any message.
+			played.steps = []streamStep{{status: prevStatus}}
+			res.script = played
+			return res
+		}
+		for _, mm := range res.Messages {
+			played.steps = append(played.steps, streamStep{message: mm})
+		}
+		for _, st := range sc.steps {
+			switch {
+			case st.message != nil:
+				res.Messages = append(res.Messages, st.message)
+			case st.status != nil:
+				res.Status = st.status
+			}
+		}
+		played.steps = append(played.steps, sc.steps...)
+		if prevStatus != nil && prevStatus.Err() != nil {
+			// StatusAfterMessages ends the stream with the status set by the previous handlers after the steps.
+			played.steps = append(played.steps, streamStep{status: prevStatus})
+		}
+		res.script = played
+		return res
+	}
+	return sc
+}
+
+// Send append step which sends message.
+func (sc *streamScript) Send(message any) *streamScript {
+	mm, err := convertMessage(message)
+	if err != nil {
+		sc.m.t.Fatalf("failed to convert message: %v", err)
+	}
+	return sc.append(streamStep{message: mm})
+}
+
+// Wait append step which waits for d before the next step.
+func (sc *streamScript) Wait(d time.Duration) *streamScript {
+	return sc.append(streamStep{wait: d})
+}
+
+// Error append step which ends the stream with status.
+// The steps after Error are ignored.
+func (sc *streamScript) Error(s *status.Status) *streamScript {
+	return sc.append(streamStep{status: s})
+}
+
+// End returns the matcher of the script to continue the method chain (e.g. Times, Delay).
+func (sc *streamScript) End() *matcher {
+	return sc.m
+}
+
+func (sc *streamScript) append(st streamStep) *streamScript {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.steps = append(sc.steps, st)
+	return sc
+}
+
+func (sc *streamScript) play(stream grpc.ServerStream, md protoreflect.MethodDescriptor) error {
+	sc.mu.RLock()
+	steps := sc.steps
+	sc.mu.RUnlock()
+	for _, st := range steps {
+		switch {
+		case st.message != nil:
+			if err := sendMessage(stream, md, st.message); err != nil {
+				return err
+			}
+		case st.status != nil:
+			return st.status.Err()
+		default:
+			if err := sleep(stream.Context(), st.wait); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
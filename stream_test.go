@@ -0,0 +1,249 @@
+package grpcstub
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/k1LoW/grpcstub/testdata/routeguide"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestStreamScript(t *testing.T) {
+	tests := []struct {
+		name     string
+		fn       func(m *matcher)
+		want     []string
+		wantCode codes.Code
+		minTime  time.Duration
+	}{
+		{
+			"Send",
+			func(m *matcher) {
+				m.Stream().Send(map[string]any{"name": "hello"}).Send(map[string]any{"name": "world"})
+			},
+			[]string{"hello", "world"},
+			codes.OK,
+			0,
+		},
+		{
+			"Wait",
+			func(m *matcher) {
+				m.Stream().Send(map[string]any{"name": "hello"}).Wait(100 * time.Millisecond).Send(map[string]any{"name": "world"})
+			},
+			[]string{"hello", "world"},
+			codes.OK,
+			100 * time.Millisecond,
+		},
+		{
+			"Error",
+			func(m *matcher) {
+				m.Stream().Send(map[string]any{"name": "hello"}).Error(status.New(codes.Unavailable, "unavailable")).Send(map[string]any{"name": "world"})
+			},
+			[]string{"hello"},
+			codes.Unavailable,
+			0,
+		},
+		{
+			"Messages before Stream",
+			func(m *matcher) {
+				m.Response(map[string]any{"name": "first"}).Stream().Send(map[string]any{"name": "hello"})
+			},
+			[]string{"first", "hello"},
+			codes.OK,
+			0,
+		},
+		{
+			"Status before Stream",
+			func(m *matcher) {
+				m.Status(status.New(codes.PermissionDenied, "denied")).Stream().Send(map[string]any{"name": "hello"})
+			},
+			nil,
+			codes.PermissionDenied,
+			0,
+		},
+		{
+			"StatusAfterMessages before Stream",
+			func(m *matcher) {
+				m.Response(map[string]any{"name": "first"}).StatusAfterMessages(status.New(codes.Aborted, "aborted")).Stream().Send(map[string]any{"name": "hello"})
+			},
+			[]string{"first", "hello"},
+			codes.Aborted,
+			0,
+		},
+	}
+	ctx := context.Background()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := NewServer(t, "testdata/route_guide.proto")
+			t.Cleanup(func() {
+				ts.Close()
+			})
+			tt.fn(ts.Method("ListFeatures"))
+
+			client := routeguide.NewRouteGuideClient(ts.Conn())
+			start := time.Now()
+			stream, err := client.ListFeatures(ctx, &routeguide.Rectangle{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			var gotErr error
+			for {
+				res, err := stream.Recv()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					gotErr = err
+					break
+				}
+				got = append(got, res.Name)
+			}
+			if diff := cmp.Diff(got, tt.want); diff != "" {
+				t.Error(diff)
+			}
+			if got := status.Code(gotErr); got != tt.wantCode {
+				t.Errorf("got %v\nwant %v", got, tt.wantCode)
+			}
+			if got := time.Since(start); got < tt.minTime {
+				t.Errorf("got %v\nwant >= %v", got, tt.minTime)
+			}
+		})
+	}
+}
+
+func TestStreamScriptEnd(t *testing.T) {
+	ctx := context.Background()
+	ts := NewServer(t, "testdata/route_guide.proto")
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	ts.Method("ListFeatures").Stream().Send(map[string]any{"name": "scripted"}).End().Once()
+	ts.Method("ListFeatures").Response(map[string]any{"name": "default"})
+	client := routeguide.NewRouteGuideClient(ts.Conn())
+	var got []string
+	for range 2 {
+		stream, err := client.ListFeatures(ctx, &routeguide.Rectangle{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, res.Name)
+	}
+	if diff := cmp.Diff(got, []string{"scripted", "default"}); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestStreamScriptBiStreaming(t *testing.T) {
+	ctx := context.Background()
+	ts := NewServer(t, "testdata/route_guide.proto")
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	ts.Method("RouteChat").Stream().Send(map[string]any{"message": "hello"}).Send(map[string]any{"message": "world"})
+
+	client := routeguide.NewRouteGuideClient(ts.Conn())
+	stream, err := client.RouteChat(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stream.Send(&routeguide.RouteNote{Message: "hello from client"}); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for range 2 {
+		res, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, res.Message)
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(got, []string{"hello", "world"}); diff != "" {
+		t.Error(diff)
+	}
+}
+
+func TestStatusAfterMessages(t *testing.T) {
+	t.Run("ServerStreaming", func(t *testing.T) {
+		ctx := context.Background()
+		ts := NewServer(t, "testdata/route_guide.proto")
+		t.Cleanup(func() {
+			ts.Close()
+		})
+		st, err := status.New(codes.Unavailable, "retry later").WithDetails(&errdetails.RetryInfo{RetryDelay: durationpb.New(time.Second)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ts.Method("ListFeatures").Response(map[string]any{"name": "hello"}).Response(map[string]any{"name": "world"}).StatusAfterMessages(st)
+
+		client := routeguide.NewRouteGuideClient(ts.Conn())
+		stream, err := client.ListFeatures(ctx, &routeguide.Rectangle{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for {
+			res, err := stream.Recv()
+			if err != nil {
+				s := status.Convert(err)
+				if got := s.Code(); got != codes.Unavailable {
+					t.Errorf("got %v\nwant %v", got, codes.Unavailable)
+				}
+				if got, want := s.Message(), "retry later"; got != want {
+					t.Errorf("got %v\nwant %v", got, want)
+				}
+				if got, want := len(s.Details()), 1; got != want {
+					t.Errorf("got %v\nwant %v", got, want)
+				}
+				break
+			}
+			got = append(got, res.Name)
+		}
+		if diff := cmp.Diff(got, []string{"hello", "world"}); diff != "" {
+			t.Error(diff)
+		}
+	})
+
+	t.Run("BiStreaming", func(t *testing.T) {
+		ctx := context.Background()
+		ts := NewServer(t, "testdata/route_guide.proto")
+		t.Cleanup(func() {
+			ts.Close()
+		})
+		ts.Method("RouteChat").Response(map[string]any{"message": "hello"}).StatusAfterMessages(status.New(codes.Unavailable, "unavailable"))
+
+		client := routeguide.NewRouteGuideClient(ts.Conn())
+		stream, err := client.RouteChat(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := stream.Send(&routeguide.RouteNote{Message: "hello from client"}); err != nil {
+			t.Fatal(err)
+		}
+		res, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "hello"; res.Message != want {
+			t.Errorf("got %v\nwant %v", res.Message, want)
+		}
+		_, err = stream.Recv()
+		if got := status.Code(err); got != codes.Unavailable {
+			t.Errorf("got %v\nwant %v", got, codes.Unavailable)
+		}
+	})
+}
@@ -0,0 +1,277 @@
+package grpcstub
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-yaml"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// stubFile is a file of stub definitions written in YAML or JSON.
+//
+//	stubs:
+//	  - service: routeguide.RouteGuide
+//	    method: GetFeature
+//	    match:
+//	      headers:
+//	        x-tenant: alice
+//	      message:
+//	        latitude: 10
+//	    response:
+//	      headers:
+//	        session: xxx
+//	      messages:
+//	        - name: hello
+//	      status:
+//	        code: NotFound
+//	        message: not found
+//	      delay: 100ms
+type stubFile struct {
+	Stubs []*stub `yaml:"stubs" json:"stubs"`
+}
+
+type stub struct {
+	Service  string       `yaml:"service,omitempty" json:"service,omitempty"`
+	Method   string       `yaml:"method,omitempty" json:"method,omitempty"`
+	Scenario string       `yaml:"scenario,omitempty" json:"scenario,omitempty"`
+	InState  string       `yaml:"inState,omitempty" json:"inState,omitempty"`
+	SetState string       `yaml:"setState,omitempty" json:"setState,omitempty"`
+	Match    stubMatch    `yaml:"match,omitempty" json:"match,omitempty"`
+	Response stubResponse `yaml:"response" json:"response"`
+}
+
+type stubMatch struct {
+	Headers    stubHeaders    `yaml:"headers,omitempty" json:"headers,omitempty"`
+	Message    map[string]any `yaml:"message,omitempty" json:"message,omitempty"`
+	ClientCert string         `yaml:"clientCert,omitempty" json:"clientCert,omitempty"`
+}
+
+type stubResponse struct {
+	Headers  stubHeaders   `yaml:"headers,omitempty" json:"headers,omitempty"`
+	Messages []Message     `yaml:"messages,omitempty" json:"messages,omitempty"`
+	Template string        `yaml:"template,omitempty" json:"template,omitempty"`
+	Trailers stubHeaders   `yaml:"trailers,omitempty" json:"trailers,omitempty"`
+	Status   *recordStatus `yaml:"status,omitempty" json:"status,omitempty"`
+	Delay    string        `yaml:"delay,omitempty" json:"delay,omitempty"`
+}
+
+// stubHeaders is headers whose values are either a string or a list of strings.
+type stubHeaders map[string][]string
+
+func (h *stubHeaders) UnmarshalYAML(unmarshal func(any) error) error {
+	var raw map[string]any
+	if err := unmarshal(&raw); err != nil {
+		return err
+	}
+	*h = stubHeaders{}
+	for k, v := range raw {
+		switch vv := v.(type) {
+		case []any:
+			for _, s := range vv {
+				(*h)[k] = append((*h)[k], fmt.Sprint(s))
+			}
+		default:
+			(*h)[k] = []string{fmt.Sprint(vv)}
+		}
+	}
+	return nil
+}
+
+// LoadStubs load stub definition files (YAML or JSON) and register matchers.
+// When path is a directory, *.yaml, *.yml and *.json files in it are loaded.
+func (s *Server) LoadStubs(paths ...string) {
+	s.t.Helper()
+	for _, p := range paths {
+		if err := s.loadStubs(p); err != nil {
+			s.t.Fatal(err)
+		}
+	}
+}
+
+func (s *Server) loadStubs(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if fi.IsDir() {
+		entries, err := os.ReadDir(path)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if e.IsDir() {
+				continue
+			}
+			switch filepath.Ext(e.Name()) {
+			case ".yaml", ".yml", ".json":
+				if err := s.loadStubs(filepath.Join(path, e.Name())); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	sf := &stubFile{}
+	if err := yaml.UnmarshalWithOptions(b, sf, yaml.DisallowUnknownField()); err != nil {
+		return fmt.Errorf("failed to load stubs %s: %w", path, err)
+	}
+	for i, st := range sf.Stubs {
+		if _, err := s.addStub(st); err != nil {
+			return fmt.Errorf("invalid stub %s[%d]: %w", path, i, err)
+		}
+	}
+	return nil
+}
+
+// addStub validates the stub against the compiled descriptors and registers the matcher.
+func (s *Server) addStub(st *stub) (*matcher, error) {
+	mds, err := s.findMethods(st.Service, st.Method)
+	if err != nil {
+		return nil, err
+	}
+	for _, md := range mds {
+		if st.Match.Message != nil {
+			if err := UnmarshalProtoMessage(st.Match.Message, dynamicpb.NewMessage(md.Input())); err != nil {
+				return nil, fmt.Errorf("invalid match message for %s: %w", md.FullName(), err)
+			}
+		}
+		for _, m := range st.Response.Messages {
+			if err := UnmarshalProtoMessage(m, dynamicpb.NewMessage(md.Output())); err != nil {
+				return nil, fmt.Errorf("invalid response message for %s: %w", md.FullName(), err)
+			}
+		}
+	}
+
+	var fns []matchFunc
+	if st.Service != "" {
+		fns = append(fns, serviceMatchFunc(st.Service))
+	}
+	if st.Method != "" {
+		fns = append(fns, methodMatchFunc(st.Method))
+	}
+	for k, v := range st.Match.Headers {
+		for _, vv := range v {
+			fns = append(fns, headerMatchFunc(k, vv))
+		}
+	}
+	if st.Match.ClientCert != "" {
+		fns = append(fns, clientCertMatchFunc(st.Match.ClientCert))
+	}
+	if st.Match.Message != nil {
+		fn, err := messageMatchFunc(st.Match.Message)
+		if err != nil {
+			return nil, err
+		}
+		fns = append(fns, fn)
+	}
+
+	var sts *status.Status
+	if st.Response.Status != nil {
+		c, err := parseCode(st.Response.Status.Code)
+		if err != nil {
+			return nil, err
+		}
+		var details []any
+		for _, d := range st.Response.Status.Details {
+			details = append(details, d)
+		}
+		sts, err = s.statusWithDetails(c, st.Response.Status.Message, details...)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var rt *responseTemplate
+	if st.Response.Template != "" {
+		rt, err = newResponseTemplate(st.Response.Template)
+		if err != nil {
+			return nil, fmt.Errorf("invalid response template: %w", err)
+		}
+	}
+	var d time.Duration
+	if st.Response.Delay != "" {
+		d, err = time.ParseDuration(st.Response.Delay)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	m := &matcher{
+		matchFuncs: fns,
+		handler: func(req *Request, md protoreflect.MethodDescriptor) *Response {
+			res := NewResponse()
+			res.Headers = metadata.MD(st.Response.Headers).Copy()
+			res.Trailers = metadata.MD(st.Response.Trailers).Copy()
+			res.Messages = append(res.Messages, st.Response.Messages...)
+			if rt != nil {
+				mm, err := rt.execute(req, md)
+				if err != nil {
+					res.Status = status.New(codes.Internal, err.Error())
+					return res
+				}
+				res.Messages = append(res.Messages, mm)
+			}
+			res.Status = sts
+			res.StatusAfterMessages = sts != nil && len(res.Messages) > 0
+			return res
+		},
+		delay:    delay{min: d, max: d},
+		stub:     st,
+		scenario: st.Scenario,
+		inState:  st.InState,
+		newState: st.SetState,
+		s:        s,
+		t:        s.t,
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.addMatcher(m)
+	return m, nil
+}
+
+// findMethods returns the method descriptors matching service and method.
+// method is either a method name or "service/method".
+func (s *Server) findMethods(service, method string) ([]protoreflect.MethodDescriptor, error) {
+	if strings.Contains(method, "/") {
+		splitted := strings.Split(strings.TrimPrefix(method, "/"), "/")
+		service = strings.Join(splitted[:len(splitted)-1], "/")
+		method = splitted[len(splitted)-1]
+	}
+	service = strings.TrimPrefix(service, "/")
+	var mds []protoreflect.MethodDescriptor
+	foundService := false
+	for _, fd := range s.fds {
+		for i := 0; i < fd.Services().Len(); i++ {
+			sd := fd.Services().Get(i)
+			if service != "" && string(sd.FullName()) != service {
+				continue
+			}
+			foundService = true
+			for j := 0; j < sd.Methods().Len(); j++ {
+				md := sd.Methods().Get(j)
+				if method != "" && string(md.Name()) != method {
+					continue
+				}
+				mds = append(mds, md)
+			}
+		}
+	}
+	if !foundService {
+		return nil, fmt.Errorf("service not found: %s", service)
+	}
+	if len(mds) == 0 {
+		return nil, fmt.Errorf("method not found: %s", method)
+	}
+	return mds, nil
+}
@@ -0,0 +1,194 @@
+package grpcstub
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/k1LoW/grpcstub/testdata/routeguide"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/testing/protocmp"
+)
+
+func TestStubFile(t *testing.T) {
+	ctx := context.Background()
+	ts := NewServer(t, "testdata/route_guide.proto", StubFile("testdata/stubs/route_guide.yaml", "testdata/stubs/default.json"))
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	client := routeguide.NewRouteGuideClient(ts.Conn())
+
+	t.Run("match headers and message", func(t *testing.T) {
+		var header metadata.MD
+		ctx := metadata.AppendToOutgoingContext(ctx, "x-tenant", "alice")
+		res, err := client.GetFeature(ctx, &routeguide.Point{Latitude: 10}, grpc.Header(&header))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "alice"; res.Name != want {
+			t.Errorf("got %v\nwant %v", res.Name, want)
+		}
+		if diff := cmp.Diff(header.Get("session"), []string{"alice"}); diff != "" {
+			t.Error(diff)
+		}
+	})
+
+	t.Run("status with details", func(t *testing.T) {
+		_, err := client.GetFeature(ctx, &routeguide.Point{Latitude: 99})
+		s, ok := status.FromError(err)
+		if !ok {
+			t.Fatal("want status.Status")
+		}
+		if got := s.Code(); got != codes.NotFound {
+			t.Errorf("got %v\nwant %v", got, codes.NotFound)
+		}
+		want := []any{&errdetails.ErrorInfo{Reason: "NOT_FOUND"}}
+		if diff := cmp.Diff(s.Details(), want, protocmp.Transform()); diff != "" {
+			t.Error(diff)
+		}
+	})
+
+	t.Run("default", func(t *testing.T) {
+		var trailer metadata.MD
+		res, err := client.GetFeature(ctx, &routeguide.Point{Latitude: 10}, grpc.Trailer(&trailer))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "default"; res.Name != want {
+			t.Errorf("got %v\nwant %v", res.Name, want)
+		}
+		if diff := cmp.Diff(trailer.Get("x-stub"), []string{"json", "default"}); diff != "" {
+			t.Error(diff)
+		}
+	})
+
+	t.Run("messages and status", func(t *testing.T) {
+		stream, err := client.ListFeatures(ctx, &routeguide.Rectangle{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for {
+			res, err := stream.Recv()
+			if err != nil {
+				if errors.Is(err, io.EOF) {
+					t.Fatal("want status error")
+				}
+				if got := status.Code(err); got != codes.Unavailable {
+					t.Errorf("got %v\nwant %v", got, codes.Unavailable)
+				}
+				break
+			}
+			names = append(names, res.Name)
+		}
+		if diff := cmp.Diff(names, []string{"hello", "world"}); diff != "" {
+			t.Error(diff)
+		}
+	})
+}
+
+func TestLoadStubsDir(t *testing.T) {
+	ctx := context.Background()
+	ts := NewServer(t, "testdata/route_guide.proto")
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	ts.LoadStubs("testdata/stubs")
+
+	client := routeguide.NewRouteGuideClient(ts.Conn())
+	// Files in the directory are loaded in lexical order, so default.json comes first.
+	res, err := client.GetFeature(ctx, &routeguide.Point{Latitude: 99})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "default"; res.Name != want {
+		t.Errorf("got %v\nwant %v", res.Name, want)
+	}
+	stream, err := client.ListFeatures(ctx, &routeguide.Rectangle{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := stream.Recv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "hello"; got.Name != want {
+		t.Errorf("got %v\nwant %v", got.Name, want)
+	}
+}
+
+func TestLoadStubsInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+	}{
+		{"unknown field", "stubs:\n  - method: GetFeature\n    respons: {}\n"},
+		{"unknown service", "stubs:\n  - service: routeguide.Unknown\n    response: {}\n"},
+		{"unknown method", "stubs:\n  - method: Unknown\n    response: {}\n"},
+		{"invalid match message", "stubs:\n  - method: GetFeature\n    match:\n      message:\n        altitude: 10\n    response: {}\n"},
+		{"invalid response message", "stubs:\n  - method: GetFeature\n    response:\n      messages:\n        - title: hello\n"},
+		{"invalid status code", "stubs:\n  - method: GetFeature\n    response:\n      status:\n        code: Unknown_Code\n"},
+		{"invalid delay", "stubs:\n  - method: GetFeature\n    response:\n      delay: 1\n"},
+		{"invalid template", "stubs:\n  - method: GetFeature\n    response:\n      template: '{{.Message.name'\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ts := NewServer(t, "testdata/route_guide.proto")
+			t.Cleanup(func() {
+				ts.Close()
+			})
+			p := filepath.Join(t.TempDir(), "stubs.yaml")
+			if err := os.WriteFile(p, []byte(tt.in), 0600); err != nil {
+				t.Fatal(err)
+			}
+			if err := ts.loadStubs(p); err == nil {
+				t.Error("want error")
+			}
+		})
+	}
+}
+
+func TestStubMatchJSONName(t *testing.T) {
+	ts := NewServer(t, "testdata/gateway/bookstore.proto", ImportPath("testdata/gateway"))
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	p := filepath.Join(t.TempDir(), "stubs.yaml")
+	in := "stubs:\n  - method: ListBooks\n    match:\n      message:\n        pageSize: 5\n    response:\n      messages:\n        - books: [{title: stub}]\n"
+	if err := os.WriteFile(p, []byte(in), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ts.loadStubs(p); err != nil {
+		t.Fatal(err)
+	}
+	ts.Method("ListBooks").MatchMessage(map[string]any{"showUnpublished": true}).Response(map[string]any{"books": []any{map[string]any{"title": "go"}}})
+
+	tests := []struct {
+		in   Message
+		want string
+	}{
+		{Message{"page_size": 5}, "stub"},
+		{Message{"show_unpublished": true}, "go"},
+	}
+	for _, tt := range tests {
+		res, err := invoke(ts, "bookstore.Bookstore", "ListBooks", tt.in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		books, _ := res["books"].([]any)
+		if len(books) != 1 {
+			t.Fatalf("got %v\nwant 1 book", res)
+		}
+		if got := books[0].(map[string]any)["title"]; got != tt.want {
+			t.Errorf("got %v\nwant %v", got, tt.want)
+		}
+	}
+}
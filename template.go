@@ -0,0 +1,107 @@
+package grpcstub
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"text/template"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// responseTemplate is the template of the response message in JSON evaluated against the request.
+type responseTemplate struct {
+	tmpl     *template.Template
+	counters map[string]int
+	mu       sync.Mutex
+}
+
+func newResponseTemplate(text string) (*responseTemplate, error) {
+	rt := &responseTemplate{counters: map[string]int{}}
+	tmpl, err := template.New("response").Funcs(rt.funcs()).Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	rt.tmpl = tmpl
+	return rt, nil
+}
+
+func (rt *responseTemplate) funcs() template.FuncMap {
+	return template.FuncMap{
+		"json": func(v any) (string, error) {
+			b, err := json.Marshal(v)
+			if err != nil {
+				return "", err
+			}
+			return string(b), nil
+		},
+		"uuid": newUUID,
+		"now":  time.Now,
+		"counter": func(name string) int {
+			rt.mu.Lock()
+			defer rt.mu.Unlock()
+			rt.counters[name]++
+			return rt.counters[name]
+		},
+	}
+}
+
+// newUUID returns UUID v4.
+func newUUID() (string, error) {
+	var u [16]byte
+	if _, err := rand.Read(u[:]); err != nil {
+		return "", err
+	}
+	u[6] = (u[6] & 0x0f) | 0x40 // version 4
+	u[8] = (u[8] & 0x3f) | 0x80 // variant 10
+	return fmt.Sprintf("%x-%x-%x-%x-%x", u[0:4], u[4:6], u[6:8], u[8:10], u[10:]), nil
+}
+
+// execute evaluates the template against the request and validates the result as the output message of md.
+func (rt *responseTemplate) execute(req *Request, md protoreflect.MethodDescriptor) (Message, error) {
+	buf := new(bytes.Buffer)
+	if err := rt.tmpl.Execute(buf, req); err != nil {
+		return nil, err
+	}
+	m := Message{}
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		return nil, fmt.Errorf("invalid response template result: %w: %s", err, buf.String())
+	}
+	if err := UnmarshalProtoMessage(m, dynamicpb.NewMessage(md.Output())); err != nil {
+		return nil, fmt.Errorf("invalid response template result for %s: %w", md.FullName(), err)
+	}
+	return m, nil
+}
+
+// ResponseTemplate set handler which return response rendered from the template with the request.
+// The template is Go text/template which renders the response message in JSON, and the data is *Request (e.g. {{.Message.name}}, {{index .Headers "x-tenant" 0}}).
+// The functions json, uuid, now and counter are available (e.g. {"id": "{{uuid}}", "location": {{json .Message.location}}, "seq": {{counter "seq"}}}).
+func (m *matcher) ResponseTemplate(tmpl string) *matcher {
+	rt, err := newResponseTemplate(tmpl)
+	if err != nil {
+		m.t.Fatalf("failed to parse response template: %v", err)
+	}
+	prev := m.handler
+	m.handler = func(req *Request, md protoreflect.MethodDescriptor) *Response {
+		var res *Response
+		if prev == nil {
+			res = NewResponse()
+		} else {
+			res = prev(req, md)
+		}
+		mm, err := rt.execute(req, md)
+		if err != nil {
+			res.Status = status.New(codes.Internal, err.Error())
+			return res
+		}
+		res.Messages = append(res.Messages, mm)
+		return res
+	}
+	return m
+}
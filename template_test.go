@@ -0,0 +1,106 @@
+package grpcstub
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/k1LoW/grpcstub/testdata/routeguide"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestResponseTemplate(t *testing.T) {
+	ctx := context.Background()
+	ts := NewServer(t, "testdata/route_guide.proto")
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	ts.Method("GetFeature").MatchHeader("x-invalid", "true").ResponseTemplate(`{"title": "{{.Message.latitude}}"}`)
+	ts.Method("GetFeature").ResponseTemplate(`{"name": "{{index .Headers "x-tenant" 0}}:{{counter "seq"}}", "location": {{json .Message}}}`)
+	ts.Method("ListFeatures").ResponseTemplate(`{"name": "{{uuid}}"}`).ResponseTemplate(`{"name": "{{.Method}}"}`)
+	client := routeguide.NewRouteGuideClient(ts.Conn())
+
+	t.Run("echo", func(t *testing.T) {
+		ctx := metadata.AppendToOutgoingContext(ctx, "x-tenant", "alice")
+		for i := 1; i <= 2; i++ {
+			res, err := client.GetFeature(ctx, &routeguide.Point{Latitude: 10, Longitude: 13})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, want := res.Name, fmt.Sprintf("alice:%d", i); got != want {
+				t.Errorf("got %v\nwant %v", got, want)
+			}
+			if got, want := res.Location.Longitude, int32(13); got != want {
+				t.Errorf("got %v\nwant %v", got, want)
+			}
+		}
+	})
+
+	t.Run("multiple messages", func(t *testing.T) {
+		stream, err := client.ListFeatures(ctx, &routeguide.Rectangle{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`).MatchString(res.Name) {
+			t.Errorf("got %v\nwant uuid", res.Name)
+		}
+		res, err = stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := res.Name, "ListFeatures"; got != want {
+			t.Errorf("got %v\nwant %v", got, want)
+		}
+	})
+
+	t.Run("invalid result", func(t *testing.T) {
+		ctx := metadata.AppendToOutgoingContext(ctx, "x-invalid", "true")
+		_, err := client.GetFeature(ctx, &routeguide.Point{})
+		if got, want := status.Code(err), codes.Internal; got != want {
+			t.Errorf("got %v\nwant %v", got, want)
+		}
+	})
+}
+
+func TestResponseTemplateStubFile(t *testing.T) {
+	ctx := context.Background()
+	p := filepath.Join(t.TempDir(), "stubs.yaml")
+	in := `stubs:
+  - method: GetFeature
+    response:
+      headers:
+        session: xxx
+      template: |
+        {"name": "hello {{.Message.latitude}}"}
+`
+	if err := os.WriteFile(p, []byte(in), 0600); err != nil {
+		t.Fatal(err)
+	}
+	ts := NewServer(t, "testdata/route_guide.proto", StubFile(p))
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	client := routeguide.NewRouteGuideClient(ts.Conn())
+	res, err := client.GetFeature(ctx, &routeguide.Point{Latitude: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := res.Name, "hello 10"; got != want {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+}
+
+func TestNewResponseTemplateInvalid(t *testing.T) {
+	if _, err := newResponseTemplate(`{"name": "{{.Message.name"}`); err == nil {
+		t.Error("want error")
+	}
+}
@@ -0,0 +1,127 @@
+package grpcstub
+
+import (
+	"context"
+	"fmt"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	protov2 "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// HandleUnary set handler using the generated message types of the unary method.
+func HandleUnary[Req, Res protov2.Message](m *matcher, fn func(ctx context.Context, req Req) (Res, error)) *matcher {
+	m.handler = func(r *Request, md protoreflect.MethodDescriptor) *Response {
+		req, err := As[Req](r)
+		if err != nil {
+			return typedErrorResponse(err)
+		}
+		res, err := fn(r.Context(), req)
+		return typedResponse(md, []protov2.Message{res}, err)
+	}
+	return m
+}
+
+// HandleServerStreaming set handler using the generated message types of the server streaming method.
+// The messages passed to send are sent to the client immediately.
+func HandleServerStreaming[Req, Res protov2.Message](m *matcher, fn func(ctx context.Context, req Req, send func(Res) error) error) *matcher {
+	m.handler = func(r *Request, md protoreflect.MethodDescriptor) *Response {
+		req, err := As[Req](r)
+		if err != nil {
+			return typedErrorResponse(err)
+		}
+		send, msgs := typedSender[Res](r, md)
+		err = fn(r.Context(), req, send)
+		return typedResponse(md, *msgs, err)
+	}
+	return m
+}
+
+// HandleClientStreaming set handler using the generated message types of the client streaming method.
+// fn receives all messages of the client stream.
+func HandleClientStreaming[Req, Res protov2.Message](m *matcher, fn func(ctx context.Context, reqs []Req) (Res, error)) *matcher {
+	m.handler = func(r *Request, md protoreflect.MethodDescriptor) *Response {
+		reqs := []Req{}
+		for _, rr := range r.stream {
+			req, err := As[Req](rr)
+			if err != nil {
+				return typedErrorResponse(err)
+			}
+			reqs = append(reqs, req)
+		}
+		res, err := fn(r.Context(), reqs)
+		return typedResponse(md, []protov2.Message{res}, err)
+	}
+	return m
+}
+
+// HandleBidiStreaming set handler using the generated message types of the bidirectional streaming method.
+// fn is called for each received message, and the messages passed to send are sent to the client immediately.
+func HandleBidiStreaming[Req, Res protov2.Message](m *matcher, fn func(ctx context.Context, req Req, send func(Res) error) error) *matcher {
+	m.handler = func(r *Request, md protoreflect.MethodDescriptor) *Response {
+		req, err := As[Req](r)
+		if err != nil {
+			return typedErrorResponse(err)
+		}
+		send, msgs := typedSender[Res](r, md)
+		err = fn(r.Context(), req, send)
+		return typedResponse(md, *msgs, err)
+	}
+	return m
+}
+
+// typedSender returns send function which writes the message to the stream of the request.
+// When the request has no stream (e.g. called without the server), the messages are buffered to msgs.
+func typedSender[Res protov2.Message](r *Request, md protoreflect.MethodDescriptor) (send func(Res) error, msgs *[]protov2.Message) {
+	msgs = &[]protov2.Message{}
+	send = func(res Res) error {
+		if r.serverStream == nil {
+			*msgs = append(*msgs, res)
+			return nil
+		}
+		mm, err := typedMessage(md, res)
+		if err != nil || mm == nil {
+			return err
+		}
+		return sendMessage(r.serverStream, md, mm)
+	}
+	return send, msgs
+}
+
+// typedResponse converts the messages and the error returned by the typed handler to the response.
+func typedResponse(md protoreflect.MethodDescriptor, msgs []protov2.Message, err error) *Response {
+	res := NewResponse()
+	for _, msg := range msgs {
+		mm, merr := typedMessage(md, msg)
+		if merr != nil {
+			return typedErrorResponse(merr)
+		}
+		if mm == nil {
+			continue
+		}
+		res.Messages = append(res.Messages, mm)
+	}
+	if err != nil {
+		res.Status = status.Convert(err)
+		res.StatusAfterMessages = len(res.Messages) > 0
+	}
+	return res
+}
+
+// typedMessage converts the message returned by the typed handler. It returns nil for nil message.
+func typedMessage(md protoreflect.MethodDescriptor, msg protov2.Message) (Message, error) {
+	if msg == nil || !msg.ProtoReflect().IsValid() {
+		return nil, nil
+	}
+	if got, want := msg.ProtoReflect().Descriptor().FullName(), md.Output().FullName(); got != want {
+		return nil, fmt.Errorf("mismatched message type: got %s, want %s", got, want)
+	}
+	return MarshalProtoMessage(msg)
+}
+
+func typedErrorResponse(err error) *Response {
+	res := NewResponse()
+	res.Status = status.New(codes.Internal, err.Error())
+	return res
+}
@@ -0,0 +1,229 @@
+package grpcstub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/k1LoW/grpcstub/testdata/routeguide"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestHandleUnary(t *testing.T) {
+	ctx := context.Background()
+	ts := NewServer(t, "testdata/route_guide.proto")
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	HandleUnary(ts.Method("GetFeature"), func(ctx context.Context, req *routeguide.Point) (*routeguide.Feature, error) {
+		if req.Latitude < 0 {
+			return nil, status.Error(codes.InvalidArgument, "invalid latitude")
+		}
+		md, _ := metadata.FromIncomingContext(ctx)
+		return &routeguide.Feature{
+			Name:     fmt.Sprintf("%s:%d", md.Get("x-tenant"), req.Latitude),
+			Location: req,
+		}, nil
+	})
+	client := routeguide.NewRouteGuideClient(ts.Conn())
+
+	t.Run("response", func(t *testing.T) {
+		ctx := metadata.AppendToOutgoingContext(ctx, "x-tenant", "alice")
+		res, err := client.GetFeature(ctx, &routeguide.Point{Latitude: 10, Longitude: 13})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := res.Name, "[alice]:10"; got != want {
+			t.Errorf("got %v\nwant %v", got, want)
+		}
+		if got, want := res.Location.Longitude, int32(13); got != want {
+			t.Errorf("got %v\nwant %v", got, want)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		_, err := client.GetFeature(ctx, &routeguide.Point{Latitude: -1})
+		if got, want := status.Code(err), codes.InvalidArgument; got != want {
+			t.Errorf("got %v\nwant %v", got, want)
+		}
+	})
+}
+
+func TestHandleUnaryMismatchedType(t *testing.T) {
+	ctx := context.Background()
+	ts := NewServer(t, "testdata/route_guide.proto")
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	HandleUnary(ts.Method("GetFeature"), func(ctx context.Context, req *routeguide.Rectangle) (*routeguide.Feature, error) {
+		return &routeguide.Feature{}, nil
+	})
+	HandleUnary(ts.Method("ListFeatures"), func(ctx context.Context, req *routeguide.Rectangle) (*routeguide.Point, error) {
+		return &routeguide.Point{}, nil
+	})
+	client := routeguide.NewRouteGuideClient(ts.Conn())
+	if _, err := client.GetFeature(ctx, &routeguide.Point{}); status.Code(err) != codes.Internal {
+		t.Errorf("got %v\nwant %v", status.Code(err), codes.Internal)
+	}
+	stream, err := client.ListFeatures(ctx, &routeguide.Rectangle{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); status.Code(err) != codes.Internal {
+		t.Errorf("got %v\nwant %v", status.Code(err), codes.Internal)
+	}
+}
+
+func TestHandleServerStreaming(t *testing.T) {
+	ctx := context.Background()
+	ts := NewServer(t, "testdata/route_guide.proto")
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	HandleServerStreaming(ts.Method("ListFeatures"), func(ctx context.Context, req *routeguide.Rectangle, send func(*routeguide.Feature) error) error {
+		for i := req.Lo.Latitude; i <= req.Hi.Latitude; i++ {
+			if err := send(&routeguide.Feature{Name: fmt.Sprintf("feature%d", i)}); err != nil {
+				return err
+			}
+		}
+		return status.Error(codes.Unavailable, "unavailable")
+	})
+	client := routeguide.NewRouteGuideClient(ts.Conn())
+	stream, err := client.ListFeatures(ctx, &routeguide.Rectangle{Lo: &routeguide.Point{Latitude: 1}, Hi: &routeguide.Point{Latitude: 3}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for {
+		res, err := stream.Recv()
+		if err != nil {
+			if got, want := status.Code(err), codes.Unavailable; got != want {
+				t.Errorf("got %v\nwant %v", got, want)
+			}
+			break
+		}
+		names = append(names, res.Name)
+	}
+	if got, want := fmt.Sprint(names), "[feature1 feature2 feature3]"; got != want {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+}
+
+func TestHandleClientStreaming(t *testing.T) {
+	ctx := context.Background()
+	ts := NewServer(t, "testdata/route_guide.proto")
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	HandleClientStreaming(ts.Method("RecordRoute"), func(ctx context.Context, reqs []*routeguide.Point) (*routeguide.RouteSummary, error) {
+		var distance int32
+		for _, req := range reqs {
+			distance += req.Latitude
+		}
+		return &routeguide.RouteSummary{PointCount: int32(len(reqs)), Distance: distance}, nil
+	})
+	client := routeguide.NewRouteGuideClient(ts.Conn())
+	stream, err := client.RecordRoute(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 3 {
+		if err := stream.Send(&routeguide.Point{Latitude: int32(i + 1)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	res, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := res.PointCount, int32(3); got != want {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+	if got, want := res.Distance, int32(6); got != want {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+}
+
+func TestHandleBidiStreaming(t *testing.T) {
+	ctx := context.Background()
+	ts := NewServer(t, "testdata/route_guide.proto")
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	HandleBidiStreaming(ts.Method("RouteChat"), func(ctx context.Context, req *routeguide.RouteNote, send func(*routeguide.RouteNote) error) error {
+		return send(&routeguide.RouteNote{Message: "echo: " + req.Message})
+	})
+	client := routeguide.NewRouteGuideClient(ts.Conn())
+	stream, err := client.RouteChat(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, msg := range []string{"hello", "world"} {
+		if err := stream.Send(&routeguide.RouteNote{Message: msg}); err != nil {
+			t.Fatal(err)
+		}
+		res, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := res.Message, "echo: "+msg; got != want {
+			t.Errorf("got %v\nwant %v", got, want)
+		}
+	}
+	if err := stream.CloseSend(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := stream.Recv(); !errors.Is(err, io.EOF) {
+		t.Errorf("got %v\nwant %v", err, io.EOF)
+	}
+}
+
+func TestHandleServerStreamingSendsImmediately(t *testing.T) {
+	ctx := context.Background()
+	ts := NewServer(t, "testdata/route_guide.proto")
+	t.Cleanup(func() {
+		ts.Close()
+	})
+	received := make(chan struct{})
+	HandleServerStreaming(ts.Method("ListFeatures"), func(ctx context.Context, req *routeguide.Rectangle, send func(*routeguide.Feature) error) error {
+		if err := send(&routeguide.Feature{Name: "first"}); err != nil {
+			return err
+		}
+		// Wait until the client receives the first message.
+		select {
+		case <-received:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		return send(&routeguide.Feature{Name: "second"})
+	})
+	client := routeguide.NewRouteGuideClient(ts.Conn())
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	t.Cleanup(cancel)
+	stream, err := client.ListFeatures(ctx, &routeguide.Rectangle{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for {
+		res, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) == 0 {
+			close(received)
+		}
+		got = append(got, res.Name)
+	}
+	if want := []string{"first", "second"}; fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got %v\nwant %v", got, want)
+	}
+}